@@ -0,0 +1,29 @@
+package security
+
+import (
+	"time"
+)
+
+// Clock is the source of time for the timeouts of the security layer. It can be replaced using WithClock for testing.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// AfterFunc calls the function in its own goroutine after the duration has elapsed.
+	AfterFunc(duration time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer started by Clock.AfterFunc.
+type ClockTimer interface {
+	// Stop prevents the timer from firing. It returns false if the timer has already fired or has been stopped.
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (r realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (r realClock) AfterFunc(duration time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(duration, f)
+}
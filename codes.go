@@ -11,6 +11,13 @@ const EFailedSetEnv = "SECURITY_EXEC_FAILED_SETENV"
 // `SSH_ORIGINAL_COMMAND` environment variable.
 const MForcingCommand = "SECURITY_EXEC_FORCING_COMMAND"
 
+// ContainerSSH is replacing a subsystem request with an exec request for the command configured in the subsystem map.
+const MSubsystemMapped = "SECURITY_SUBSYSTEM_MAPPED"
+
+// Program execution failed because ContainerSSH could not expand the forceCommand template, e.g. because it refers to a
+// value that is not available.
+const EForceCommandFailed = "SECURITY_EXEC_FORCE_COMMAND_FAILED"
+
 // ContainerSSH rejected launching a shell due to the security settings.
 const EShellRejected = "SECURITY_SHELL_REJECTED"
 
@@ -28,3 +35,125 @@ const ESignalRejected = "SECURITY_SIGNAL_REJECTED"
 
 // The client has reached the maximum number of configured sessions, the new session request is therefore rejected.
 const EMaxSessions = "SECURITY_MAX_SESSIONS"
+
+// ContainerSSH could not look up the groups of the user with the configured group resolver and therefore rejected the
+// connection because the correct policy could not be determined.
+const EGroupResolutionFailed = "SECURITY_GROUP_RESOLUTION_FAILED"
+
+// ContainerSSH applied user or group specific policy overrides to the connection.
+const MOverridesApplied = "SECURITY_OVERRIDES_APPLIED"
+
+// ContainerSSH rejected a local port forwarding (direct-tcpip) request because it does not pass the security settings.
+const ELocalForwardingRejected = "SECURITY_LOCAL_FORWARDING_REJECTED"
+
+// ContainerSSH rejected a remote port forwarding (tcpip-forward) request because it does not pass the security
+// settings.
+const ERemoteForwardingRejected = "SECURITY_REMOTE_FORWARDING_REJECTED"
+
+// ContainerSSH rejected the X11 forwarding request because it does not pass the security settings.
+const EX11Rejected = "SECURITY_X11_REJECTED"
+
+// ContainerSSH rejected the SSH agent forwarding request because it does not pass the security settings.
+const EAgentForwardingRejected = "SECURITY_AGENT_FORWARDING_REJECTED"
+
+// ContainerSSH rejected a channel type that has no dedicated security setting because it does not pass the settings
+// for unsupported channel types.
+const EChannelTypeRejected = "SECURITY_CHANNEL_TYPE_REJECTED"
+
+// ContainerSSH rejected a global request that has no dedicated security setting because it does not pass the
+// settings for unsupported global requests.
+const EGlobalRequestRejected = "SECURITY_GLOBAL_REQUEST_REJECTED"
+
+// ContainerSSH rejected a channel request that has no dedicated security setting because it does not pass the
+// settings for unsupported channel requests.
+const EChannelRequestRejected = "SECURITY_CHANNEL_REQUEST_REJECTED"
+
+// The security layer made a policy decision. This message is only written if the logger decision recorder is
+// configured.
+const MDecision = "SECURITY_DECISION"
+
+// The security layer could not write a policy decision to the decision recorder.
+const EDecisionRecordFailed = "SECURITY_DECISION_RECORD_FAILED"
+
+// The security configuration was reloaded. The changedFields label contains the top level options that changed.
+const MConfigReloaded = "SECURITY_CONFIG_RELOADED"
+
+// The security configuration could not be reloaded because it is invalid. The previous configuration stays in effect.
+const EConfigReloadFailed = "SECURITY_CONFIG_RELOAD_FAILED"
+
+// The security layer closed a live session, either on request through the session registry or because its program
+// request is rejected by a newly loaded configuration.
+const ESessionTerminated = "SECURITY_SESSION_TERMINATED"
+
+// The session has been closed because it reached the configured MaxSessionDuration.
+const ESessionDurationExceeded = "SECURITY_SESSION_DURATION_EXCEEDED"
+
+// The session has been closed because it had no input or output for the configured IdleTimeout.
+const ESessionIdleTimeout = "SECURITY_SESSION_IDLE_TIMEOUT"
+
+// The user has been warned that the session will be closed due to MaxSessionDuration or IdleTimeout.
+const MSessionTimeoutWarning = "SECURITY_SESSION_TIMEOUT_WARNING"
+
+// The session has been closed because it exceeded a data transfer quota.
+const EQuotaExceeded = "SECURITY_QUOTA_EXCEEDED"
+
+// The session exceeded a data transfer quota and its bandwidth is now limited.
+const MQuotaThrottled = "SECURITY_QUOTA_THROTTLED"
+
+// ContainerSSH rejected a program request because it requires a TTY and the client has not requested one.
+const ETTYRequired = "SECURITY_TTY_REQUIRED"
+
+// ContainerSSH rejected a program request because it must be run without a TTY and the client has requested one.
+const ETTYForbidden = "SECURITY_TTY_FORBIDDEN"
+
+// ContainerSSH rejected a window change request because of the TTY security settings.
+const EWindowChangeRejected = "SECURITY_WINDOW_CHANGE_REJECTED"
+
+// The dimensions of a window change request were outside the TTY bounds and have been clamped.
+const MWindowChangeClamped = "SECURITY_WINDOW_CHANGE_CLAMPED"
+
+// A window change request has been held back because of the resize interval. Only the last window change is passed to
+// the backend when the interval elapses.
+const MWindowChangeCoalesced = "SECURITY_WINDOW_CHANGE_COALESCED"
+
+// The backend failed to process a window change that has been held back because of the resize interval.
+const EWindowChangeFailed = "SECURITY_WINDOW_CHANGE_FAILED"
+
+// A session channel request exceeded a configured rate limit. Depending on the configured action the request is
+// rejected, the session is closed, or all sessions of the connection are closed.
+const ERequestRateLimited = "SECURITY_REQUEST_RATE_LIMITED"
+
+// A session channel request is rejected because it was sent in the wrong order according to the sequencing setting,
+// e.g. a second exec request or an env request after the program has been started.
+const ERequestOutOfOrder = "SECURITY_REQUEST_OUT_OF_ORDER"
+
+// A channel or request is rejected because the security layer closed the connection, for example due to a rate limit.
+const EConnectionDisconnected = "SECURITY_CONNECTION_DISCONNECTED"
+
+// The security layer is in audit mode and would have rejected a request. The request is passed to the backend anyway.
+// The original rejection code is included in the rejectionCode label.
+const MAuditWouldReject = "SECURITY_AUDIT_WOULD_REJECT"
+
+// ContainerSSH rejected the incoming connection because the client address does not pass the network security
+// settings.
+const EConnectionRejected = "SECURITY_CONNECTION_REJECTED"
+
+// The user has reached the maximum number of concurrent connections across all connections handled by the security
+// layer, the new connection is therefore rejected.
+const EMaxUserConnections = "SECURITY_MAX_USER_CONNECTIONS"
+
+// The client IP address has reached the maximum number of concurrent connections, the new connection is therefore
+// rejected.
+const EMaxIPConnections = "SECURITY_MAX_IP_CONNECTIONS"
+
+// The user has reached the maximum number of concurrent sessions across all of their connections, the new session
+// request is therefore rejected.
+const EMaxUserSessions = "SECURITY_MAX_USER_SESSIONS"
+
+// The client IP address has reached the maximum number of concurrent sessions across all connections, the new session
+// request is therefore rejected.
+const EMaxIPSessions = "SECURITY_MAX_IP_SESSIONS"
+
+// The client has reached the maximum number of sessions allowed over the lifetime of a single connection, the new
+// session request is therefore rejected.
+const EMaxTotalSessions = "SECURITY_MAX_TOTAL_SESSIONS"
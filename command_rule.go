@@ -0,0 +1,208 @@
+package security
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/mattn/go-shellwords"
+)
+
+// CommandRule is a structured allow rule for command (exec) requests. The command sent by the client is split into
+// words using shell quoting rules, the first word is compared to Program, and the remaining words are matched against
+// Args and RemainingArgs.
+//
+// Arguments containing a `..` path segment only match if they also match once the path is cleaned, so
+// `/repos/../etc/shadow` is treated like `/etc/shadow` and cannot escape a rule such as `glob:/repos/*`.
+type CommandRule struct {
+	// Program is matched exactly against the first word (argv[0]) of the command.
+	Program string `json:"program" yaml:"program"`
+	// Args contains one pattern for each positional argument following the program. Entries are matched exactly
+	// unless they are prefixed with PatternPrefixGlob or PatternPrefixRegex. The command must have at least as many
+	// arguments as there are entries in Args.
+	Args []string `json:"args" yaml:"args"`
+	// RemainingArgs is a pattern every argument after the positional Args must match. If empty, no further arguments
+	// are permitted.
+	RemainingArgs string `json:"remainingArgs" yaml:"remainingArgs"`
+	// AllowShellMetacharacters permits commands that contain unquoted shell metacharacters such as `;`, `|` or `$`.
+	// Commands containing metacharacters are rejected by default because the backend may pass them to a shell.
+	AllowShellMetacharacters bool `json:"allowShellMetacharacters" yaml:"allowShellMetacharacters"`
+	// ForceCommand replaces commands matching this rule. It takes precedence over the ForceCommand of the command
+	// section and the global ForceCommand and works the same way.
+	ForceCommand string `json:"forceCommand" yaml:"forceCommand"`
+	// RequireTTY rejects commands matching this rule if no TTY has been allocated.
+	RequireTTY bool `json:"requireTTY" yaml:"requireTTY"`
+	// ForbidTTY rejects commands matching this rule if a TTY has been allocated.
+	ForbidTTY bool `json:"forbidTTY" yaml:"forbidTTY"`
+}
+
+// Validate validates the command rule.
+func (r CommandRule) Validate() error {
+	if r.Program == "" {
+		return fmt.Errorf("no program specified")
+	}
+	if err := validatePatterns(r.Args); err != nil {
+		return fmt.Errorf("invalid args (%w)", err)
+	}
+	if r.RemainingArgs != "" {
+		if _, err := compilePattern(r.RemainingArgs); err != nil {
+			return fmt.Errorf("invalid remainingArgs (%w)", err)
+		}
+	}
+	if err := validateForceCommand(r.ForceCommand); err != nil {
+		return err
+	}
+	if r.RequireTTY && r.ForbidTTY {
+		return fmt.Errorf("requireTTY and forbidTTY cannot be set at the same time")
+	}
+	return nil
+}
+
+// String returns a short description of the rule for logging.
+func (r CommandRule) String() string {
+	return fmt.Sprintf("program=%s args=%v remainingArgs=%s", r.Program, r.Args, r.RemainingArgs)
+}
+
+func (r CommandRule) match(parsed *parsedConfig, argv []string) bool {
+	if len(argv) == 0 || argv[0] != r.Program {
+		return false
+	}
+	args := argv[1:]
+	if len(args) < len(r.Args) {
+		return false
+	}
+	for i, pattern := range r.Args {
+		if !matchArg(parsed, pattern, args[i]) {
+			return false
+		}
+	}
+	remaining := args[len(r.Args):]
+	if len(remaining) > 0 && r.RemainingArgs == "" {
+		return false
+	}
+	for _, arg := range remaining {
+		if !matchArg(parsed, r.RemainingArgs, arg) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchArg matches a single argument against a pattern. Arguments with a `..` path segment must match both as they are
+// and after cleaning the path, since the program resolves the `..` segments.
+func matchArg(parsed *parsedConfig, pattern string, arg string) bool {
+	if _, ok := parsed.matchAny([]string{pattern}, arg); !ok {
+		return false
+	}
+	if !hasParentSegment(arg) {
+		return true
+	}
+	_, ok := parsed.matchAny([]string{pattern}, path.Clean(arg))
+	return ok
+}
+
+// hasParentSegment returns true if the argument contains a `..` path segment.
+func hasParentSegment(arg string) bool {
+	for _, segment := range strings.Split(arg, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCommand checks a command against the allow list and the command rules. It returns the allow list entry or rule
+// that matched, or the reason why the command was not allowed.
+func (c CommandConfig) matchCommand(parsed *parsedConfig, program string) (matched string, reason string, ok bool) {
+	matched, _, reason, ok = c.matchCommandRule(parsed, program)
+	return matched, reason, ok
+}
+
+// matchCommandRule works like matchCommand, but also returns the command rule that matched. The rule is nil if the
+// command matched the allow list.
+func (c CommandConfig) matchCommandRule(
+	parsed *parsedConfig,
+	program string,
+) (matched string, rule *CommandRule, reason string, ok bool) {
+	for _, allowed := range c.Allow {
+		if allowed == program {
+			return allowed, nil, "", true
+		}
+	}
+	metacharacters := hasShellMetacharacters(program)
+	if !metacharacters {
+		if matched, ok := parsed.matchAny(c.Allow, program); ok {
+			return matched, nil, "", true
+		}
+	}
+	argv, err := parseCommand(program)
+	if err != nil {
+		return "", nil, fmt.Sprintf("The command could not be parsed (%v).", err), false
+	}
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if metacharacters && !rule.AllowShellMetacharacters {
+			continue
+		}
+		if rule.match(parsed, argv) {
+			return rule.String(), rule, "", true
+		}
+	}
+	if metacharacters {
+		return "", nil, "The specified command contains shell metacharacters and no command rule permits them.", false
+	}
+	return "", nil, "The specified command passed from the client does not match the specified allow list.", false
+}
+
+// parseCommand splits the command into words using shell quoting rules. Commands that contain unquoted control
+// operators cannot be represented as a single argument list and are rejected.
+func parseCommand(program string) ([]string, error) {
+	parser := shellwords.NewParser()
+	parser.ParseEnv = false
+	parser.ParseBacktick = false
+	argv, err := parser.Parse(program)
+	if err != nil {
+		return nil, err
+	}
+	if parser.Position != -1 {
+		return nil, fmt.Errorf("unquoted control operator at position %d", parser.Position)
+	}
+	return argv, nil
+}
+
+// hasShellMetacharacters returns true if the command contains characters a shell would interpret. Characters inside
+// single quotes are literal, inside double quotes only `$` and backticks are special.
+func hasShellMetacharacters(program string) bool {
+	singleQuoted := false
+	doubleQuoted := false
+	escaped := false
+	for _, r := range program {
+		switch {
+		case escaped:
+			escaped = false
+		case singleQuoted:
+			if r == '\'' {
+				singleQuoted = false
+			}
+		case r == '\\':
+			escaped = true
+		case doubleQuoted:
+			switch r {
+			case '"':
+				doubleQuoted = false
+			case '$', '`':
+				return true
+			}
+		case r == '\'':
+			singleQuoted = true
+		case r == '"':
+			doubleQuoted = true
+		default:
+			switch r {
+			case ';', '&', '|', '<', '>', '(', ')', '$', '`', '*', '?', '[', ']', '{', '}', '~', '\n', '\r':
+				return true
+			}
+		}
+	}
+	return false
+}
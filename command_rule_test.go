@@ -0,0 +1,75 @@
+package security
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandRules(t *testing.T) {
+	config := CommandConfig{
+		Mode:  ExecutionPolicyFilter,
+		Allow: []string{"/bin/exact;echo"},
+		Rules: []CommandRule{
+			{
+				Program:       "git-upload-pack",
+				RemainingArgs: "glob:/repos/*",
+			},
+			{
+				Program: "rsync",
+				Args:    []string{"--server", "regex:-[a-zA-Z.]+"},
+			},
+			{
+				Program:                  "git",
+				Args:                     []string{"log"},
+				RemainingArgs:            "glob:*",
+				AllowShellMetacharacters: true,
+			},
+		},
+	}
+	assert.NoError(t, config.Validate())
+
+	for program, allowed := range map[string]bool{
+		"/bin/exact;echo":                             true,
+		"git-upload-pack '/repos/foo.git'":            true,
+		"git-upload-pack /repos/foo.git /repos/b.git": true,
+		"git-upload-pack":                             true,
+		"git-upload-pack /etc/passwd":                 false,
+		"git-upload-pack /repos/../etc/shadow":        false,
+		"git-upload-pack /repos/foo/../../etc/shadow": false,
+		"git-upload-pack /repos/..":                   false,
+		"git-upload-pack /repos/foo/../bar.git":       true,
+		"git log HEAD..main":                          true,
+		"git-upload-pack '/repos/foo.git'; rm -rf /":  false,
+		"git-upload-pack /repos/$(id)":                false,
+		"git-upload-pack \"/repos/$HOME\"":            false,
+		"git-upload-pack '/repos/$HOME'":              true,
+		"rsync --server -vlogDtpre.iLsfxC":            true,
+		"rsync --server":                              false,
+		"rsync --server -v extra":                     false,
+		"git log --format='%h|%s'":                    true,
+		"git log --format=%h|%s":                      false,
+		"git log 'unterminated":                       false,
+		"/bin/sh":                                     false,
+	} {
+		_, _, ok := config.matchCommand(nil, program)
+		assert.Equal(t, allowed, ok, program)
+	}
+}
+
+func TestCommandRulePatternAllowRejectsMetacharacters(t *testing.T) {
+	config := CommandConfig{
+		Mode:  ExecutionPolicyFilter,
+		Allow: []string{"glob:ls *"},
+	}
+	_, _, ok := config.matchCommand(nil, "ls -la")
+	assert.True(t, ok)
+	_, _, ok = config.matchCommand(nil, "ls -la; rm -rf /")
+	assert.False(t, ok)
+}
+
+func TestCommandRuleValidation(t *testing.T) {
+	assert.Error(t, CommandRule{}.Validate())
+	assert.Error(t, CommandRule{Program: "ls", Args: []string{"regex:("}}.Validate())
+	assert.Error(t, CommandRule{Program: "ls", RemainingArgs: "glob:["}.Validate())
+}
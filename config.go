@@ -2,6 +2,7 @@ package security
 
 import (
 	"fmt"
+	"time"
 )
 
 // Config is the configuration structure for security settings.
@@ -10,10 +11,21 @@ type Config struct {
 	// if for restricted setups to avoid accidentally allowing new features coming in with version upgrades.
 	DefaultMode ExecutionPolicy `json:"defaultMode" yaml:"defaultMode"`
 
+	// Enforcement sets the default enforcement mode for all policy decisions. Setting this to "audit" logs the requests
+	// that would have been rejected instead of rejecting them. Individual sections can override this setting.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
+
 	// ForceCommand behaves similar to the OpenSSH ForceCommand option. When set this command overrides any command
 	// requested by the client and executes this command instead. The original command supplied by the client will be
 	// set in the `SSH_ORIGINAL_COMMAND` environment variable.
 	//
+	// The command is a Go template with ForceCommandTemplateData as its data, e.g. `/usr/bin/menu {{ quote .Username }}`.
+	// The command is run by a shell, so values chosen by the client, such as `.OriginalCommand`, `.Subsystem` and
+	// `.Username`, must be passed through `quote`. Inserting them unquoted allows shell injection. A literal `{{` must
+	// be written as `{{ "{{" }}`, existing commands containing it are rejected or expanded differently otherwise.
+	// The ForceCommand settings of the command rules and the command, shell and subsystem sections take precedence over
+	// this setting.
+	//
 	// Setting ForceCommand changes subsystem requests into exec requests for the backends.
 	ForceCommand string `json:"forceCommand" yaml:"forceCommand"`
 
@@ -35,6 +47,72 @@ type Config struct {
 	// MaxSessions drives how many session channels can be open at the same time for a single network connection.
 	// -1 means unlimited. It is strongly recommended to configure this to a sane value, e.g. 10.
 	MaxSessions int `json:"maxSessions" yaml:"maxSessions" default:"-1"`
+	// MaxTotalSessions limits how many session channels can be opened over the lifetime of a single network
+	// connection, regardless of how many of them are already closed. 0 or -1 means unlimited.
+	MaxTotalSessions int `json:"maxTotalSessions" yaml:"maxTotalSessions" default:"-1"`
+	// MaxSessionDuration closes sessions that have been open for longer than the specified time. 0 means unlimited.
+	MaxSessionDuration time.Duration `json:"maxSessionDuration" yaml:"maxSessionDuration"`
+	// IdleTimeout closes sessions that had no input or output for the specified time. 0 means no idle timeout.
+	IdleTimeout time.Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	// TimeoutWarning is how long before closing a session due to MaxSessionDuration or IdleTimeout the user is warned
+	// on the standard error. 0 disables the warning.
+	TimeoutWarning time.Duration `json:"timeoutWarning" yaml:"timeoutWarning" default:"1m"`
+	// Sequencing controls how strictly the order of requests on a session channel is enforced, e.g. rejecting env
+	// requests after the program has been started. Defaults to off.
+	Sequencing SequencingMode `json:"sequencing" yaml:"sequencing"`
+	// RateLimits limits how often clients may send session channel requests.
+	RateLimits RateLimitConfig `json:"rateLimits" yaml:"rateLimits"`
+	// Quota limits how much data can be transferred through sessions.
+	Quota QuotaConfig `json:"quota" yaml:"quota"`
+	// Limits configures concurrency limits per user and per client IP address that are shared across connections.
+	Limits LimitsConfig `json:"limits" yaml:"limits"`
+
+	// Forwarding controls TCP port forwarding requests.
+	Forwarding ForwardingConfig `json:"forwarding" yaml:"forwarding"`
+	// X11 controls X11 forwarding requests.
+	X11 X11Config `json:"x11" yaml:"x11"`
+	// AgentForwarding controls SSH agent forwarding requests.
+	AgentForwarding AgentForwardingConfig `json:"agentForwarding" yaml:"agentForwarding"`
+	// Unsupported controls channel types and requests that have no dedicated section.
+	Unsupported UnsupportedConfig `json:"unsupported" yaml:"unsupported"`
+
+	// Users contains policy overrides keyed by username. The user override is applied after all group overrides and
+	// therefore takes precedence over them.
+	Users map[string]OverrideConfig `json:"users" yaml:"users"`
+	// Groups contains policy overrides for groups. Group membership is looked up using the GroupResolver passed to New.
+	// If a user is a member of multiple groups the overrides are applied in the order they are listed here.
+	Groups []GroupOverrideConfig `json:"groups" yaml:"groups"`
+
+	// Network controls which client networks may connect. This only takes effect when using NewHandler.
+	Network NetworkConfig `json:"network" yaml:"network"`
+	// Sources contains policy overrides for clients connecting from specific networks. These are applied before the
+	// group and user overrides, which take precedence over them, and only take effect when using NewHandler.
+	Sources []SourceOverrideConfig `json:"sources" yaml:"sources"`
+
+	// ReevaluateLiveSessions applies the configuration to connections and sessions that are already open when it is
+	// loaded using ConfigHolder.Reload. Otherwise live connections keep the configuration they were opened with.
+	ReevaluateLiveSessions bool `json:"reevaluateLiveSessions" yaml:"reevaluateLiveSessions"`
+	// TerminateViolatingSessions closes live sessions whose exec, shell or subsystem request is rejected by the
+	// configuration when it is loaded using ConfigHolder.Reload. This implies ReevaluateLiveSessions.
+	TerminateViolatingSessions bool `json:"terminateViolatingSessions" yaml:"terminateViolatingSessions"`
+	// TerminationGracePeriod is the time the backend is given to shut down a session before the security layer closes
+	// the channel. 0 means the default of 10 seconds.
+	TerminationGracePeriod time.Duration `json:"terminationGracePeriod" yaml:"terminationGracePeriod" default:"10s"`
+
+	// parsed holds the parsed allow and deny list entries and templates once the configuration has been applied.
+	parsed *parsedConfig
+}
+
+// defaultTerminationGracePeriod is used when TerminationGracePeriod is not set, e.g. in configurations created in Go
+// code, which do not get the default from the struct tag.
+const defaultTerminationGracePeriod = 10 * time.Second
+
+// gracePeriod returns the TerminationGracePeriod, or the default if it is not set.
+func (c Config) gracePeriod() time.Duration {
+	if c.TerminationGracePeriod == 0 {
+		return defaultTerminationGracePeriod
+	}
+	return c.TerminationGracePeriod
 }
 
 // Validate validates a shell configuration
@@ -42,6 +120,12 @@ func (c Config) Validate() error {
 	if err := c.DefaultMode.Validate(); err != nil {
 		return fmt.Errorf("invalid defaultMode configuration (%w)", err)
 	}
+	if err := c.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement configuration (%w)", err)
+	}
+	if err := validateForceCommand(c.ForceCommand); err != nil {
+		return err
+	}
 	if err := c.Env.Validate(); err != nil {
 		return fmt.Errorf("invalid env configuration (%w)", err)
 	}
@@ -60,9 +144,54 @@ func (c Config) Validate() error {
 	if err := c.Signal.Validate(); err != nil {
 		return fmt.Errorf("invalid signal configuration (%w)", err)
 	}
+	if err := c.Sequencing.Validate(); err != nil {
+		return fmt.Errorf("invalid sequencing configuration (%w)", err)
+	}
+	if err := c.RateLimits.Validate(); err != nil {
+		return fmt.Errorf("invalid rateLimits configuration (%w)", err)
+	}
+	if err := c.Quota.Validate(); err != nil {
+		return fmt.Errorf("invalid quota configuration (%w)", err)
+	}
+	if err := c.Forwarding.Validate(); err != nil {
+		return fmt.Errorf("invalid forwarding configuration (%w)", err)
+	}
+	if err := c.X11.Validate(); err != nil {
+		return fmt.Errorf("invalid X11 configuration (%w)", err)
+	}
+	if err := c.AgentForwarding.Validate(); err != nil {
+		return fmt.Errorf("invalid agent forwarding configuration (%w)", err)
+	}
+	if err := c.Unsupported.Validate(); err != nil {
+		return fmt.Errorf("invalid unsupported configuration (%w)", err)
+	}
+	if err := c.Network.Validate(); err != nil {
+		return fmt.Errorf("invalid network configuration (%w)", err)
+	}
 	if c.MaxSessions < -1 {
 		return fmt.Errorf("invalid maxSessions setting: %d", c.MaxSessions)
 	}
+	if c.MaxSessionDuration < 0 {
+		return fmt.Errorf("invalid maxSessionDuration setting: %s", c.MaxSessionDuration)
+	}
+	if c.IdleTimeout < 0 {
+		return fmt.Errorf("invalid idleTimeout setting: %s", c.IdleTimeout)
+	}
+	if c.TimeoutWarning < 0 {
+		return fmt.Errorf("invalid timeoutWarning setting: %s", c.TimeoutWarning)
+	}
+	if c.TerminationGracePeriod < 0 {
+		return fmt.Errorf("invalid terminationGracePeriod setting: %s", c.TerminationGracePeriod)
+	}
+	if c.MaxTotalSessions < -1 {
+		return fmt.Errorf("invalid maxTotalSessions setting: %d", c.MaxTotalSessions)
+	}
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits configuration (%w)", err)
+	}
+	if err := c.validateOverrides(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -70,12 +199,26 @@ func (c Config) Validate() error {
 type EnvConfig struct {
 	// Mode configures how to treat environment variable requests by SSH clients.
 	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Enforcement overrides the global enforcement mode for environment variable requests.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
 	// Allow takes effect when Mode is ExecutionPolicyFilter and only allows the specified environment variables to be
-	// set.
+	// set. Entries are matched exactly unless they are prefixed with PatternPrefixGlob or PatternPrefixRegex, e.g.
+	// `glob:LC_*`.
 	Allow []string `json:"allow" yaml:"allow"`
 	// Allow takes effect when Mode is not ExecutionPolicyDisable and disallows the specified environment variables to
 	// be set.
 	Deny []string `json:"deny" yaml:"deny"`
+	// Set contains environment variables the security layer sets before the program is started. The values are Go
+	// templates with EnvTemplateData as their data, e.g. `{{ .Username }}`. Clients cannot set these variables
+	// themselves.
+	Set map[string]string `json:"set" yaml:"set"`
+	// Values constrains the values of environment variables by name. All entries matching the variable name apply.
+	Values []EnvValueConfig `json:"values" yaml:"values"`
+	// MaxVariables limits how many environment variables a session can set. 0 means unlimited.
+	MaxVariables int `json:"maxVariables" yaml:"maxVariables"`
+	// MaxTotalBytes limits the total size of the names and values of the environment variables set in a session.
+	// 0 means unlimited.
+	MaxTotalBytes int `json:"maxTotalBytes" yaml:"maxTotalBytes"`
 }
 
 // Validate validates a shell configuration
@@ -83,6 +226,29 @@ func (e EnvConfig) Validate() error {
 	if err := e.Mode.Validate(); err != nil {
 		return fmt.Errorf("invalid mode (%w)", err)
 	}
+	if err := e.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement (%w)", err)
+	}
+	if err := validatePatterns(e.Allow); err != nil {
+		return fmt.Errorf("invalid allow list (%w)", err)
+	}
+	if err := validatePatterns(e.Deny); err != nil {
+		return fmt.Errorf("invalid deny list (%w)", err)
+	}
+	if err := validateEnvSet(e.Set); err != nil {
+		return fmt.Errorf("invalid set configuration (%w)", err)
+	}
+	for i, value := range e.Values {
+		if err := value.Validate(); err != nil {
+			return fmt.Errorf("invalid values entry %d (%w)", i, err)
+		}
+	}
+	if e.MaxVariables < 0 {
+		return fmt.Errorf("invalid maxVariables setting: %d", e.MaxVariables)
+	}
+	if e.MaxTotalBytes < 0 {
+		return fmt.Errorf("invalid maxTotalBytes setting: %d", e.MaxTotalBytes)
+	}
 	return nil
 }
 
@@ -90,9 +256,23 @@ func (e EnvConfig) Validate() error {
 type CommandConfig struct {
 	// Mode configures how to treat command execution (exec) requests by SSH clients.
 	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Enforcement overrides the global enforcement mode for command execution requests.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
 	// Allow takes effect when Mode is ExecutionPolicyFilter and only allows the specified commands to be
-	// executed. Note that the match an exact match is performed to avoid shell injections, etc.
+	// executed. Note that the match an exact match is performed to avoid shell injections, etc. unless the entry is
+	// explicitly prefixed with PatternPrefixGlob or PatternPrefixRegex. Pattern entries never match commands that
+	// contain shell metacharacters.
 	Allow []string `json:"allow" yaml:"allow"`
+	// Rules takes effect when Mode is ExecutionPolicyFilter and allows commands that match one of the structured
+	// rules in addition to the Allow list.
+	Rules []CommandRule `json:"rules" yaml:"rules"`
+	// ForceCommand replaces the command of all command execution requests. It takes precedence over the global
+	// ForceCommand and works the same way.
+	ForceCommand string `json:"forceCommand" yaml:"forceCommand"`
+	// RequireTTY rejects command execution requests if no TTY has been allocated.
+	RequireTTY bool `json:"requireTTY" yaml:"requireTTY"`
+	// ForbidTTY rejects command execution requests if a TTY has been allocated.
+	ForbidTTY bool `json:"forbidTTY" yaml:"forbidTTY"`
 }
 
 // Validate validates a shell configuration
@@ -100,6 +280,23 @@ func (c CommandConfig) Validate() error {
 	if err := c.Mode.Validate(); err != nil {
 		return fmt.Errorf("invalid mode (%w)", err)
 	}
+	if err := c.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement (%w)", err)
+	}
+	if err := validatePatterns(c.Allow); err != nil {
+		return fmt.Errorf("invalid allow list (%w)", err)
+	}
+	for i, rule := range c.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid rule %d (%w)", i, err)
+		}
+	}
+	if err := validateForceCommand(c.ForceCommand); err != nil {
+		return err
+	}
+	if c.RequireTTY && c.ForbidTTY {
+		return fmt.Errorf("requireTTY and forbidTTY cannot be set at the same time")
+	}
 	return nil
 }
 
@@ -107,6 +304,15 @@ func (c CommandConfig) Validate() error {
 type ShellConfig struct {
 	// Mode configures how to treat shell requests by SSH clients.
 	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Enforcement overrides the global enforcement mode for shell requests.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
+	// ForceCommand executes the specified command instead of shells, e.g. a menu program. It takes precedence over the
+	// global ForceCommand and works the same way.
+	ForceCommand string `json:"forceCommand" yaml:"forceCommand"`
+	// RequireTTY rejects shell requests if no TTY has been allocated.
+	RequireTTY bool `json:"requireTTY" yaml:"requireTTY"`
+	// ForbidTTY rejects shell requests if a TTY has been allocated.
+	ForbidTTY bool `json:"forbidTTY" yaml:"forbidTTY"`
 }
 
 // Validate validates a shell configuration
@@ -114,6 +320,15 @@ func (s ShellConfig) Validate() error {
 	if err := s.Mode.Validate(); err != nil {
 		return fmt.Errorf("invalid mode (%w)", err)
 	}
+	if err := s.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement (%w)", err)
+	}
+	if err := validateForceCommand(s.ForceCommand); err != nil {
+		return err
+	}
+	if s.RequireTTY && s.ForbidTTY {
+		return fmt.Errorf("requireTTY and forbidTTY cannot be set at the same time")
+	}
 	return nil
 }
 
@@ -121,11 +336,26 @@ func (s ShellConfig) Validate() error {
 type SubsystemConfig struct {
 	// Mode configures how to treat subsystem requests by SSH clients.
 	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Enforcement overrides the global enforcement mode for subsystem requests.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
 	// Allow takes effect when Mode is ExecutionPolicyFilter and only allows the specified subsystems to be
-	// executed.
+	// executed. Entries may be prefixed with PatternPrefixGlob or PatternPrefixRegex.
 	Allow []string `json:"allow" yaml:"allow"`
 	// Allow takes effect when Mode is not ExecutionPolicyDisable and disallows the specified subsystems to be executed.
 	Deny []string `json:"deny" yaml:"deny"`
+	// ForceCommands contains forced commands keyed by subsystem name, e.g. a wrapper for `sftp`. The `*` key applies to
+	// all subsystems without a dedicated entry. The entries take precedence over the global ForceCommand and work the
+	// same way, an empty entry exempts the subsystem from the global ForceCommand.
+	ForceCommands map[string]string `json:"forceCommands" yaml:"forceCommands"`
+	// Map rewrites the listed subsystems into exec requests for the specified commands, e.g. mapping `sftp` to
+	// `/usr/lib/openssh/sftp-server`. Unlike ForceCommands the command is passed to the backend as is and
+	// `SSH_ORIGINAL_COMMAND` is not set. Subsystems not listed are passed to the backend unchanged. Unlike
+	// ForceCommands the map does not support a `*` entry. A forced command takes precedence over the mapping.
+	Map map[string]string `json:"map" yaml:"map"`
+	// RequireTTY rejects subsystem requests if no TTY has been allocated.
+	RequireTTY bool `json:"requireTTY" yaml:"requireTTY"`
+	// ForbidTTY rejects subsystem requests if a TTY has been allocated.
+	ForbidTTY bool `json:"forbidTTY" yaml:"forbidTTY"`
 }
 
 // Validate validates a subsystem configuration
@@ -133,6 +363,32 @@ func (s SubsystemConfig) Validate() error {
 	if err := s.Mode.Validate(); err != nil {
 		return fmt.Errorf("invalid mode (%w)", err)
 	}
+	if err := s.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement (%w)", err)
+	}
+	if err := validatePatterns(s.Allow); err != nil {
+		return fmt.Errorf("invalid allow list (%w)", err)
+	}
+	if err := validatePatterns(s.Deny); err != nil {
+		return fmt.Errorf("invalid deny list (%w)", err)
+	}
+	if err := validateForceCommands(s.ForceCommands); err != nil {
+		return err
+	}
+	for subsystem, command := range s.Map {
+		if subsystem == "" {
+			return fmt.Errorf("empty subsystem name in map")
+		}
+		if subsystem == "*" {
+			return fmt.Errorf("the subsystem map does not support the * entry")
+		}
+		if command == "" {
+			return fmt.Errorf("empty command for subsystem %s in map", subsystem)
+		}
+	}
+	if s.RequireTTY && s.ForbidTTY {
+		return fmt.Errorf("requireTTY and forbidTTY cannot be set at the same time")
+	}
 	return nil
 }
 
@@ -140,6 +396,31 @@ func (s SubsystemConfig) Validate() error {
 type TTYConfig struct {
 	// Mode configures how to treat TTY/PTY requests by SSH clients.
 	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Enforcement overrides the global enforcement mode for TTY/PTY requests.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
+	// Allow takes effect when Mode is ExecutionPolicyFilter and only allows the specified terminal types, e.g.
+	// `xterm-256color`. Entries may be prefixed with PatternPrefixGlob or PatternPrefixRegex.
+	Allow []string `json:"allow" yaml:"allow"`
+	// Deny takes effect when Mode is not ExecutionPolicyDisable and rejects the specified terminal types.
+	Deny []string `json:"deny" yaml:"deny"`
+	// Columns limits the terminal width in characters when Mode is ExecutionPolicyFilter.
+	Columns TTYBounds `json:"columns" yaml:"columns"`
+	// Rows limits the terminal height in characters when Mode is ExecutionPolicyFilter.
+	Rows TTYBounds `json:"rows" yaml:"rows"`
+	// Width limits the terminal width in pixels when Mode is ExecutionPolicyFilter.
+	Width TTYBounds `json:"width" yaml:"width"`
+	// Height limits the terminal height in pixels when Mode is ExecutionPolicyFilter.
+	Height TTYBounds `json:"height" yaml:"height"`
+	// Modes filters the terminal modes requested by the client when Mode is ExecutionPolicyFilter. The first rule
+	// matching a mode applies.
+	Modes []TTYModeRule `json:"modes" yaml:"modes"`
+	// ResizeAction configures how window changes outside the Columns, Rows, Width and Height bounds are treated when
+	// Mode is ExecutionPolicyFilter. Defaults to TTYResizeActionReject. Window changes are rejected in all modes if no
+	// TTY has been allocated.
+	ResizeAction TTYResizeAction `json:"resizeAction" yaml:"resizeAction"`
+	// ResizeInterval is the minimum time between window changes passed to the backend. Window changes arriving
+	// faster are held back and only the last one is passed on. 0 passes all window changes immediately.
+	ResizeInterval time.Duration `json:"resizeInterval" yaml:"resizeInterval"`
 }
 
 // Validate validates the TTY configuration
@@ -147,6 +428,38 @@ func (t TTYConfig) Validate() error {
 	if err := t.Mode.Validate(); err != nil {
 		return fmt.Errorf("invalid mode (%w)", err)
 	}
+	if err := t.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement (%w)", err)
+	}
+	if err := validatePatterns(t.Allow); err != nil {
+		return fmt.Errorf("invalid allow list (%w)", err)
+	}
+	if err := validatePatterns(t.Deny); err != nil {
+		return fmt.Errorf("invalid deny list (%w)", err)
+	}
+	if err := t.Columns.Validate(); err != nil {
+		return fmt.Errorf("invalid columns (%w)", err)
+	}
+	if err := t.Rows.Validate(); err != nil {
+		return fmt.Errorf("invalid rows (%w)", err)
+	}
+	if err := t.Width.Validate(); err != nil {
+		return fmt.Errorf("invalid width (%w)", err)
+	}
+	if err := t.Height.Validate(); err != nil {
+		return fmt.Errorf("invalid height (%w)", err)
+	}
+	for i, mode := range t.Modes {
+		if err := mode.Validate(); err != nil {
+			return fmt.Errorf("invalid modes entry %d (%w)", i, err)
+		}
+	}
+	if err := t.ResizeAction.Validate(); err != nil {
+		return fmt.Errorf("invalid resizeAction (%w)", err)
+	}
+	if t.ResizeInterval < 0 {
+		return fmt.Errorf("invalid resizeInterval setting: %s", t.ResizeInterval)
+	}
 	return nil
 }
 
@@ -154,7 +467,10 @@ func (t TTYConfig) Validate() error {
 type SignalConfig struct {
 	// Mode configures how to treat signal requests to running programs
 	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Enforcement overrides the global enforcement mode for signal requests.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
 	// Allow takes effect when Mode is ExecutionPolicyFilter and only allows the specified signals to be forwarded.
+	// Entries may be prefixed with PatternPrefixGlob or PatternPrefixRegex.
 	Allow []string `json:"allow" yaml:"allow"`
 	// Allow takes effect when Mode is not ExecutionPolicyDisable and disallows the specified signals to be forwarded.
 	Deny []string `json:"deny" allow:"deny"`
@@ -165,9 +481,30 @@ func (s SignalConfig) Validate() error {
 	if err := s.Mode.Validate(); err != nil {
 		return fmt.Errorf("invalid mode (%w)", err)
 	}
+	if err := s.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement (%w)", err)
+	}
+	if err := validatePatterns(s.Allow); err != nil {
+		return fmt.Errorf("invalid allow list (%w)", err)
+	}
+	if err := validatePatterns(s.Deny); err != nil {
+		return fmt.Errorf("invalid deny list (%w)", err)
+	}
 	return nil
 }
 
+// getPolicy returns the primary policy if configured, or falls back to DefaultMode and finally to
+// ExecutionPolicyEnable.
+func (c Config) getPolicy(primary ExecutionPolicy) ExecutionPolicy {
+	if primary != ExecutionPolicyUnconfigured {
+		return primary
+	}
+	if c.DefaultMode != ExecutionPolicyUnconfigured {
+		return c.DefaultMode
+	}
+	return ExecutionPolicyEnable
+}
+
 // ExecutionPolicy drives how to treat a certain request.
 type ExecutionPolicy string
 
@@ -0,0 +1,172 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// ConfigHolder holds the current security configuration and allows replacing it at runtime. Pass it to New or
+// NewHandler using WithConfigHolder. ConfigHolder is safe for concurrent use.
+type ConfigHolder struct {
+	value *atomic.Value
+	lock  *sync.Mutex
+	// reevaluateLock serializes applying reloaded configurations to live connections. It is separate from lock
+	// because reevaluation calls the group resolver, which must not block the other methods of the holder.
+	reevaluateLock *sync.Mutex
+	generation     uint64
+	logger         log.Logger
+	listeners      []ConfigChangeListener
+	sessions       *SessionRegistry
+}
+
+// ConfigChangeEvent summarizes a configuration reload.
+type ConfigChangeEvent struct {
+	// Time is the time of the reload.
+	Time time.Time `json:"time"`
+	// Generation is incremented on every successful reload. The initial configuration is generation 0.
+	Generation uint64 `json:"generation"`
+	// ChangedFields contains the names of the top level configuration options that changed.
+	ChangedFields []string `json:"changedFields"`
+	// ReevaluatedConnections is the number of live connections the new configuration was applied to.
+	ReevaluatedConnections int `json:"reevaluatedConnections"`
+	// TerminatedSessions is the number of live sessions closed because they violate the new configuration.
+	TerminatedSessions int `json:"terminatedSessions"`
+}
+
+// ConfigChangeListener is called after each successful configuration reload.
+type ConfigChangeListener func(event ConfigChangeEvent)
+
+// NewConfigHolder creates a new holder with the initial configuration.
+func NewConfigHolder(config Config, logger log.Logger) (*ConfigHolder, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid security configuration (%w)", err)
+	}
+	value := &atomic.Value{}
+	value.Store(config.parse())
+	return &ConfigHolder{
+		value:          value,
+		lock:           &sync.Mutex{},
+		reevaluateLock: &sync.Mutex{},
+		logger:         logger,
+		sessions:       NewSessionRegistry(),
+	}, nil
+}
+
+// Get returns the current configuration.
+func (h *ConfigHolder) Get() Config {
+	return h.value.Load().(Config)
+}
+
+// Generation returns the number of successful reloads.
+func (h *ConfigHolder) Generation() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.generation
+}
+
+// Sessions returns the registry of the live sessions of all handlers using this holder.
+func (h *ConfigHolder) Sessions() *SessionRegistry {
+	return h.sessions
+}
+
+// OnChange registers a listener that is called after each successful reload.
+func (h *ConfigHolder) OnChange(listener ConfigChangeListener) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.listeners = append(h.listeners, listener)
+}
+
+// Reload validates the configuration and, if it is valid, replaces the current configuration. New connections and
+// sessions use the new configuration immediately. If ReevaluateLiveSessions is set in the new configuration, the
+// configuration of live connections and sessions is also replaced and applies to all subsequent requests. If
+// TerminateViolatingSessions is set, Reload also closes the live sessions whose program request is rejected by the new
+// configuration and returns when they are closed.
+func (h *ConfigHolder) Reload(config Config) error {
+	if err := config.Validate(); err != nil {
+		err := log.Wrap(err, EConfigReloadFailed, "Security configuration reload failed.")
+		h.logger.Warning(err)
+		return err
+	}
+	config = config.parse()
+	h.lock.Lock()
+	previous := h.Get()
+	h.value.Store(config)
+	h.generation++
+	event := ConfigChangeEvent{
+		Time:          time.Now(),
+		Generation:    h.generation,
+		ChangedFields: changedFields(previous, config),
+	}
+	listeners := make([]ConfigChangeListener, len(h.listeners))
+	copy(listeners, h.listeners)
+	h.lock.Unlock()
+
+	if config.ReevaluateLiveSessions || config.TerminateViolatingSessions {
+		event.ReevaluatedConnections, event.TerminatedSessions = h.reevaluate(event.Generation, config)
+	}
+
+	h.logger.Info(
+		log.NewMessage(
+			MConfigReloaded,
+			"Security configuration reloaded (generation %d), changed fields: %s",
+			event.Generation,
+			strings.Join(event.ChangedFields, ", "),
+		).Label("generation", event.Generation).Label("changedFields", event.ChangedFields),
+	)
+	for _, listener := range listeners {
+		listener(event)
+	}
+	return nil
+}
+
+// reevaluate applies the configuration of the given generation to the live connections and, if
+// TerminateViolatingSessions is set, closes the live sessions that violate it. It returns the number of connections
+// the configuration was applied to and the number of closed sessions. It is called without holding lock, so a
+// concurrent reload may already have replaced the configuration. In that case it does nothing, the live sessions must
+// not be checked against a configuration they no longer have.
+func (h *ConfigHolder) reevaluate(generation uint64, config Config) (int, int) {
+	h.reevaluateLock.Lock()
+	defer h.reevaluateLock.Unlock()
+	if h.Generation() != generation {
+		return 0, 0
+	}
+	connections := h.sessions.connectionList()
+	for _, connection := range connections {
+		connection.reevaluate(config)
+	}
+	if !config.TerminateViolatingSessions {
+		return len(connections), 0
+	}
+	shutdownContext, cancel := context.WithTimeout(context.Background(), config.gracePeriod())
+	defer cancel()
+	return len(connections), h.sessions.terminateViolating(shutdownContext)
+}
+
+// changedFields returns the names of the top level configuration options that differ between the two configurations.
+func changedFields(previous Config, current Config) []string {
+	var result []string
+	previousValue := reflect.ValueOf(previous)
+	currentValue := reflect.ValueOf(current)
+	configType := previousValue.Type()
+	for i := 0; i < configType.NumField(); i++ {
+		if configType.Field(i).PkgPath != "" {
+			continue
+		}
+		if reflect.DeepEqual(previousValue.Field(i).Interface(), currentValue.Field(i).Interface()) {
+			continue
+		}
+		name := strings.Split(configType.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = configType.Field(i).Name
+		}
+		result = append(result, name)
+	}
+	return result
+}
@@ -0,0 +1,167 @@
+package security
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHolderReload(t *testing.T) {
+	_, err := NewConfigHolder(Config{DefaultMode: "foo"}, log.NewTestLogger(t))
+	assert.Error(t, err)
+
+	holder, err := NewConfigHolder(Config{MaxSessions: -1}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	var events []ConfigChangeEvent
+	holder.OnChange(func(event ConfigChangeEvent) {
+		events = append(events, event)
+	})
+
+	assert.Error(t, holder.Reload(Config{DefaultMode: "foo"}))
+	assert.Equal(t, uint64(0), holder.Generation())
+	assert.Equal(t, ExecutionPolicyUnconfigured, holder.Get().DefaultMode)
+	assert.Equal(t, 0, len(events))
+
+	assert.NoError(t, holder.Reload(Config{
+		MaxSessions: -1,
+		Command:     CommandConfig{Mode: ExecutionPolicyDisable},
+		Env:         EnvConfig{Deny: []string{"LD_PRELOAD"}},
+	}))
+	assert.Equal(t, uint64(1), holder.Generation())
+	assert.Equal(t, ExecutionPolicyDisable, holder.Get().Command.Mode)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, uint64(1), events[0].Generation)
+	assert.Equal(t, []string{"env", "command"}, events[0].ChangedFields)
+}
+
+func TestConfigHolderNewConnections(t *testing.T) {
+	holder, err := NewConfigHolder(Config{MaxSessions: -1}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	h, err := NewHandler(
+		Config{Command: CommandConfig{Mode: ExecutionPolicyDisable}},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+		WithConfigHolder(holder),
+	)
+	assert.NoError(t, err)
+
+	oldSession := openTestSession(t, h, "127.0.0.1", "conn1")
+	assert.NoError(t, oldSession.OnExecRequest(1, "/bin/bash"))
+
+	assert.NoError(t, holder.Reload(Config{
+		MaxSessions: -1,
+		Command:     CommandConfig{Mode: ExecutionPolicyDisable},
+	}))
+	assert.NoError(t, oldSession.OnExecRequest(2, "/bin/bash"))
+	newSession := openTestSession(t, h, "127.0.0.1", "conn2")
+	assert.Error(t, newSession.OnExecRequest(1, "/bin/bash"))
+}
+
+func TestConfigHolderReevaluateLiveSessions(t *testing.T) {
+	holder, err := NewConfigHolder(Config{MaxSessions: -1}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	h, err := NewHandler(Config{}, &dummyHandler{}, log.NewTestLogger(t), WithConfigHolder(holder))
+	assert.NoError(t, err)
+
+	network, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, "conn1")
+	assert.NoError(t, err)
+	connection, err := network.OnHandshakeSuccess("user")
+	assert.NoError(t, err)
+	session, rejection := connection.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.Nil(t, rejection)
+	assert.NoError(t, session.OnExecRequest(1, "/bin/bash"))
+
+	var events []ConfigChangeEvent
+	holder.OnChange(func(event ConfigChangeEvent) {
+		events = append(events, event)
+	})
+	assert.NoError(t, holder.Reload(Config{
+		MaxSessions:            1,
+		Command:                CommandConfig{Mode: ExecutionPolicyDisable},
+		Users:                  map[string]OverrideConfig{"user": {Env: &EnvConfig{Deny: []string{"FOO"}}}},
+		ReevaluateLiveSessions: true,
+	}))
+	assert.Equal(t, 1, events[0].ReevaluatedConnections)
+	assert.Error(t, session.OnExecRequest(2, "/bin/bash"))
+	assert.Error(t, session.OnEnvRequest(3, "FOO", "bar"))
+	_, rejection = connection.OnSessionChannel(1, []byte{}, &sessionChannel{})
+	assert.NotNil(t, rejection)
+
+	session.OnClose()
+	network.OnDisconnect()
+	assert.Equal(t, 0, len(holder.Sessions().connectionList()))
+}
+
+func TestConfigHolderConcurrentReload(t *testing.T) {
+	holder, err := NewConfigHolder(Config{MaxSessions: -1}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	h, err := NewHandler(Config{}, &dummyHandler{}, log.NewTestLogger(t), WithConfigHolder(holder))
+	assert.NoError(t, err)
+	session := openTestSession(t, h, "127.0.0.1", "conn1")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = session.OnExecRequest(uint64(i), "/bin/bash")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		mode := ExecutionPolicyEnable
+		if i%2 == 0 {
+			mode = ExecutionPolicyDisable
+		}
+		assert.NoError(t, holder.Reload(Config{
+			MaxSessions:            -1,
+			Command:                CommandConfig{Mode: mode},
+			ReevaluateLiveSessions: true,
+		}))
+	}
+	<-done
+}
+
+func TestConfigHolderReloadCallsResolverWithoutLock(t *testing.T) {
+	holder, err := NewConfigHolder(Config{MaxSessions: -1}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	var generations []uint64
+	resolver := GroupResolverFunc(func(username string) ([]string, error) {
+		generations = append(generations, holder.Generation())
+		return []string{"admins"}, nil
+	})
+	h, err := NewHandler(
+		Config{},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+		WithConfigHolder(holder),
+		WithGroupResolver(resolver),
+	)
+	assert.NoError(t, err)
+	session := openTestSession(t, h, "127.0.0.1", "conn1")
+
+	done := make(chan error)
+	go func() {
+		done <- holder.Reload(Config{
+			MaxSessions: -1,
+			Groups: []GroupOverrideConfig{
+				{Group: "admins", OverrideConfig: OverrideConfig{Shell: &ShellConfig{Mode: ExecutionPolicyDisable}}},
+			},
+			ReevaluateLiveSessions: true,
+		})
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload did not finish while the group resolver read the holder")
+	}
+	assert.Equal(t, []uint64{1}, generations)
+	assert.Error(t, session.OnShell(1))
+}
+
+func TestTerminationGracePeriodDefault(t *testing.T) {
+	assert.Equal(t, 10*time.Second, Config{}.gracePeriod())
+	assert.Equal(t, time.Second, Config{TerminationGracePeriod: time.Second}.gracePeriod())
+}
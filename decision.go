@@ -0,0 +1,218 @@
+package security
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// DecisionOutcome is the result of a policy decision.
+type DecisionOutcome string
+
+const (
+	// DecisionOutcomeAllow indicates that the request was passed to the backend unchanged.
+	DecisionOutcomeAllow DecisionOutcome = "allow"
+	// DecisionOutcomeDeny indicates that the request was rejected.
+	DecisionOutcomeDeny DecisionOutcome = "deny"
+	// DecisionOutcomeRewrite indicates that the request was passed to the backend in a modified form, for example
+	// because of ForceCommand.
+	DecisionOutcomeRewrite DecisionOutcome = "rewrite"
+	// DecisionOutcomeAudit indicates that the request would have been rejected, but was passed to the backend because
+	// the enforcement mode is audit.
+	DecisionOutcomeAudit DecisionOutcome = "audit"
+)
+
+// Decision is a structured event describing the outcome of a single policy decision.
+type Decision struct {
+	// Time is the time the decision was made.
+	Time time.Time `json:"time"`
+	// RequestType is the type of the request, e.g. connection, session, env, exec, or the SSH channel or request
+	// type for forwarding and unsupported requests.
+	RequestType string `json:"requestType"`
+	// Subject is the subject of the request, such as the environment variable name, the command, or the forwarding
+	// destination.
+	Subject string `json:"subject,omitempty"`
+	// MatchedRule is the allow or deny list entry that decided the outcome, if any.
+	MatchedRule string `json:"matchedRule,omitempty"`
+	// Mode is the execution policy that was in effect for the request.
+	Mode ExecutionPolicy `json:"mode,omitempty"`
+	// Enforcement is the enforcement mode that was in effect for the request.
+	Enforcement EnforcementMode `json:"enforcement,omitempty"`
+	// Outcome is the result of the decision.
+	Outcome DecisionOutcome `json:"outcome"`
+	// Rewrite contains the replacement request if the outcome is DecisionOutcomeRewrite.
+	Rewrite string `json:"rewrite,omitempty"`
+	// Code is the message code of the rejection if the request was denied or audited.
+	Code string `json:"code,omitempty"`
+	// Username is the authenticated user, if known.
+	Username string `json:"username,omitempty"`
+	// ConnectionID is the unique identifier of the connection, if known.
+	ConnectionID string `json:"connectionId,omitempty"`
+	// ChannelID is the channel the request belongs to, if any.
+	ChannelID *uint64 `json:"channelId,omitempty"`
+	// RemoteAddress is the IP address of the client, if known.
+	RemoteAddress string `json:"remoteAddr,omitempty"`
+}
+
+// DecisionRecorder receives the policy decisions made by the security layer. Implementations must be safe for
+// concurrent use.
+type DecisionRecorder interface {
+	// RecordDecision is called once for every policy decision.
+	RecordDecision(decision Decision)
+}
+
+// DecisionRecorderFunc is an adapter to use an ordinary function as a DecisionRecorder.
+type DecisionRecorderFunc func(decision Decision)
+
+// RecordDecision calls the underlying function.
+func (f DecisionRecorderFunc) RecordDecision(decision Decision) {
+	f(decision)
+}
+
+// NewLoggerDecisionRecorder creates a DecisionRecorder that writes every decision to the logger on the debug level.
+func NewLoggerDecisionRecorder(logger log.Logger) DecisionRecorder {
+	return &loggerDecisionRecorder{logger: logger}
+}
+
+type loggerDecisionRecorder struct {
+	logger log.Logger
+}
+
+func (l *loggerDecisionRecorder) RecordDecision(decision Decision) {
+	message := log.NewMessage(
+		MDecision,
+		"Policy decision for %s request: %s",
+		decision.RequestType,
+		decision.Outcome,
+	)
+	labels := map[string]string{
+		"requestType":  decision.RequestType,
+		"subject":      decision.Subject,
+		"matchedRule":  decision.MatchedRule,
+		"mode":         string(decision.Mode),
+		"enforcement":  string(decision.Enforcement),
+		"outcome":      string(decision.Outcome),
+		"rewrite":      decision.Rewrite,
+		"code":         decision.Code,
+		"username":     decision.Username,
+		"connectionId": decision.ConnectionID,
+		"remoteAddr":   decision.RemoteAddress,
+	}
+	for name, value := range labels {
+		if value != "" {
+			message.Label(log.LabelName(name), value)
+		}
+	}
+	if decision.ChannelID != nil {
+		message.Label("channelId", *decision.ChannelID)
+	}
+	l.logger.Debug(message)
+}
+
+// NewJSONLinesDecisionRecorder creates a DecisionRecorder that writes every decision as a single line of JSON to the
+// writer, typically an *os.File. Write errors are logged to the logger as warnings.
+func NewJSONLinesDecisionRecorder(writer io.Writer, logger log.Logger) DecisionRecorder {
+	return &jsonLinesDecisionRecorder{
+		lock:   &sync.Mutex{},
+		writer: writer,
+		logger: logger,
+	}
+}
+
+type jsonLinesDecisionRecorder struct {
+	lock   *sync.Mutex
+	writer io.Writer
+	logger log.Logger
+}
+
+func (j *jsonLinesDecisionRecorder) RecordDecision(decision Decision) {
+	data, err := json.Marshal(decision)
+	if err == nil {
+		j.lock.Lock()
+		_, err = j.writer.Write(append(data, '\n'))
+		j.lock.Unlock()
+	}
+	if err != nil {
+		j.logger.Warning(log.Wrap(err, EDecisionRecordFailed, "Failed to write policy decision."))
+	}
+}
+
+// NewMemoryDecisionRecorder creates a DecisionRecorder that keeps all decisions in memory. It is intended for tests.
+func NewMemoryDecisionRecorder() *MemoryDecisionRecorder {
+	return &MemoryDecisionRecorder{
+		lock: &sync.Mutex{},
+	}
+}
+
+// MemoryDecisionRecorder keeps all decisions in memory.
+type MemoryDecisionRecorder struct {
+	lock      *sync.Mutex
+	decisions []Decision
+}
+
+// RecordDecision stores the decision.
+func (m *MemoryDecisionRecorder) RecordDecision(decision Decision) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.decisions = append(m.decisions, decision)
+}
+
+// Decisions returns a copy of the decisions recorded so far.
+func (m *MemoryDecisionRecorder) Decisions() []Decision {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := make([]Decision, len(m.decisions))
+	copy(result, m.decisions)
+	return result
+}
+
+// Reset removes all recorded decisions.
+func (m *MemoryDecisionRecorder) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.decisions = nil
+}
+
+// decide logs the rejection according to the enforcement mode, fills in the outcome and passes the decision to the
+// configured recorders. It returns true if the rejection must be enforced. A nil rejection is never enforced.
+func (o options) decide(
+	logger log.Logger,
+	enforcement EnforcementMode,
+	decision Decision,
+	rejection log.Message,
+) bool {
+	enforced := false
+	decision.Enforcement = enforcement
+	if rejection != nil {
+		decision.Code = rejection.Code()
+		if enforce(logger, enforcement, rejection) {
+			decision.Outcome = DecisionOutcomeDeny
+			enforced = true
+		} else {
+			decision.Outcome = DecisionOutcomeAudit
+		}
+	} else if decision.Outcome == "" {
+		decision.Outcome = DecisionOutcomeAllow
+	}
+	if len(o.decisionRecorders) == 0 {
+		return enforced
+	}
+	if decision.Time.IsZero() {
+		decision.Time = o.getClock().Now()
+	}
+	for _, recorder := range o.decisionRecorders {
+		recorder.RecordDecision(decision)
+	}
+	return enforced
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,92 @@
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionRecorder(t *testing.T) {
+	recorder := NewMemoryDecisionRecorder()
+	h, err := NewHandler(
+		Config{
+			MaxSessions: -1,
+			Network: NetworkConfig{
+				Mode:  ExecutionPolicyFilter,
+				Allow: []string{"127.0.0.0/8"},
+			},
+			Env: EnvConfig{
+				Deny: []string{"glob:LD_*"},
+			},
+			Command: CommandConfig{
+				Mode:  ExecutionPolicyFilter,
+				Allow: []string{"/bin/bash"},
+			},
+			ForceCommand: "/bin/wrapper",
+		},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+		WithDecisionRecorder(recorder),
+	)
+	assert.NoError(t, err)
+
+	session := openTestSession(t, h, "127.0.0.1", "conn1")
+	assert.Error(t, session.OnEnvRequest(1, "LD_PRELOAD", "foo"))
+	assert.NoError(t, session.OnExecRequest(2, "/bin/bash"))
+
+	decisions := recorder.Decisions()
+	assert.Equal(t, 5, len(decisions))
+
+	assert.Equal(t, "connection", decisions[0].RequestType)
+	assert.Equal(t, "127.0.0.0/8", decisions[0].MatchedRule)
+	assert.Equal(t, DecisionOutcomeAllow, decisions[0].Outcome)
+	assert.Equal(t, "handshake", decisions[1].RequestType)
+	assert.Equal(t, "session", decisions[2].RequestType)
+
+	env := decisions[3]
+	assert.Equal(t, "env", env.RequestType)
+	assert.Equal(t, "LD_PRELOAD", env.Subject)
+	assert.Equal(t, "glob:LD_*", env.MatchedRule)
+	assert.Equal(t, DecisionOutcomeDeny, env.Outcome)
+	assert.Equal(t, EEnvRejected, env.Code)
+	assert.Equal(t, EnforcementModeEnforce, env.Enforcement)
+	assert.Equal(t, "user", env.Username)
+	assert.Equal(t, "conn1", env.ConnectionID)
+	assert.Equal(t, "127.0.0.1", env.RemoteAddress)
+	assert.NotNil(t, env.ChannelID)
+	assert.False(t, env.Time.IsZero())
+
+	exec := decisions[4]
+	assert.Equal(t, DecisionOutcomeRewrite, exec.Outcome)
+	assert.Equal(t, "/bin/bash", exec.MatchedRule)
+	assert.Equal(t, "/bin/wrapper", exec.Rewrite)
+
+	recorder.Reset()
+	assert.Equal(t, 0, len(recorder.Decisions()))
+}
+
+func TestJSONLinesDecisionRecorder(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	recorder := NewJSONLinesDecisionRecorder(buffer, log.NewTestLogger(t))
+	recorder.RecordDecision(Decision{RequestType: "env", Subject: "FOO", Outcome: DecisionOutcomeAllow})
+	recorder.RecordDecision(Decision{RequestType: "exec", Outcome: DecisionOutcomeDeny, Code: EExecRejected})
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	decision := Decision{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &decision))
+	assert.Equal(t, "exec", decision.RequestType)
+	assert.Equal(t, DecisionOutcomeDeny, decision.Outcome)
+	assert.Equal(t, EExecRejected, decision.Code)
+}
+
+func TestLoggerDecisionRecorder(t *testing.T) {
+	channelID := uint64(1)
+	NewLoggerDecisionRecorder(log.NewTestLogger(t)).RecordDecision(
+		Decision{RequestType: "signal", Subject: "TERM", Outcome: DecisionOutcomeAllow, ChannelID: &channelID},
+	)
+}
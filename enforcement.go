@@ -0,0 +1,68 @@
+package security
+
+import (
+	"fmt"
+
+	"github.com/containerssh/log"
+)
+
+// EnforcementMode drives whether the policy decisions are enforced or only logged.
+type EnforcementMode string
+
+const (
+	// EnforcementModeUnconfigured falls back to the global enforcement mode. If unconfigured on a global level the
+	// default is to "enforce".
+	EnforcementModeUnconfigured EnforcementMode = ""
+
+	// EnforcementModeEnforce rejects requests that do not pass the security settings.
+	EnforcementModeEnforce EnforcementMode = "enforce"
+
+	// EnforcementModeAudit evaluates the security settings, but only logs the requests that would have been rejected
+	// and passes them to the backend anyway. This is useful to measure the impact of a stricter configuration before
+	// enforcing it.
+	EnforcementModeAudit EnforcementMode = "audit"
+)
+
+// Validate validates the enforcement mode.
+func (e EnforcementMode) Validate() error {
+	switch e {
+	case EnforcementModeUnconfigured:
+	case EnforcementModeEnforce:
+	case EnforcementModeAudit:
+	default:
+		return fmt.Errorf("invalid enforcement mode: %s", e)
+	}
+	return nil
+}
+
+// getEnforcement returns the section enforcement mode if configured, or falls back to the global Enforcement setting
+// and finally to EnforcementModeEnforce.
+func (c Config) getEnforcement(section EnforcementMode) EnforcementMode {
+	if section != EnforcementModeUnconfigured {
+		return section
+	}
+	if c.Enforcement != EnforcementModeUnconfigured {
+		return c.Enforcement
+	}
+	return EnforcementModeEnforce
+}
+
+// enforce logs the rejection according to the enforcement mode. It returns true if the rejection must be enforced and
+// false if the request should proceed because the enforcement mode is audit.
+func enforce(logger log.Logger, mode EnforcementMode, rejection log.Message) bool {
+	if mode != EnforcementModeAudit {
+		logger.Debug(rejection)
+		return true
+	}
+	message := log.NewMessage(
+		MAuditWouldReject,
+		"Would have rejected request: %s",
+		rejection.Explanation(),
+	)
+	for name, value := range rejection.Labels() {
+		message.Label(name, value)
+	}
+	message.Label("rejectionCode", rejection.Code())
+	logger.Info(message)
+	return false
+}
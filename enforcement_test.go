@@ -0,0 +1,109 @@
+package security
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuditMode(t *testing.T) {
+	backend := &dummyBackend{}
+	session := &sessionHandler{
+		config: Config{
+			DefaultMode: ExecutionPolicyDisable,
+			Enforcement: EnforcementModeAudit,
+		},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	assert.NoError(t, session.OnExecRequest(1, "/bin/bash"))
+	assert.Equal(t, []string{"/bin/bash"}, backend.commandsExecuted)
+	session.OnUnsupportedChannelRequest(2, "auth-agent-req@openssh.com", []byte{})
+	assert.Equal(t, []string{"auth-agent-req@openssh.com"}, backend.unsupportedRequests)
+
+	// Section settings take precedence over the global setting.
+	session.config.Command.Enforcement = EnforcementModeEnforce
+	assert.Error(t, session.OnExecRequest(3, "/bin/bash"))
+	assert.Equal(t, []string{"/bin/bash"}, backend.commandsExecuted)
+
+	session.config.Enforcement = EnforcementModeUnconfigured
+	session.config.Shell.Enforcement = EnforcementModeAudit
+	assert.NoError(t, session.OnShell(4))
+	assert.Error(t, session.OnSubsystem(5, "sftp"))
+}
+
+func TestAuditModeForwardingSections(t *testing.T) {
+	backend := &dummyBackend{}
+	session := &sessionHandler{
+		config: Config{
+			DefaultMode:     ExecutionPolicyDisable,
+			X11:             X11Config{Enforcement: EnforcementModeAudit},
+			AgentForwarding: AgentForwardingConfig{Enforcement: EnforcementModeAudit},
+		},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+	recorder := NewMemoryDecisionRecorder()
+	WithDecisionRecorder(recorder)(&session.sshConnection.options)
+
+	session.OnUnsupportedChannelRequest(1, "x11-req", ssh.Marshal(x11RequestPayload{AuthProtocol: "MIT-MAGIC-COOKIE-1"}))
+	session.OnUnsupportedChannelRequest(2, "auth-agent-req@openssh.com", []byte{})
+	assert.Equal(t, []string{"x11-req", "auth-agent-req@openssh.com"}, backend.unsupportedRequests)
+	for _, decision := range recorder.Decisions() {
+		assert.Equal(t, EnforcementModeAudit, decision.Enforcement, decision.RequestType)
+	}
+
+	session.config.AgentForwarding.Enforcement = EnforcementModeEnforce
+	session.OnUnsupportedChannelRequest(3, "auth-agent-req@openssh.com", []byte{})
+	assert.Len(t, backend.unsupportedRequests, 2)
+}
+
+func TestAuditModeSessionLimits(t *testing.T) {
+	limiter := NewLimiter()
+	ssh := &sshConnectionHandler{
+		config: Config{
+			MaxSessions: 1,
+			Limits: LimitsConfig{
+				MaxSessionsPerUser: 1,
+			},
+			Enforcement: EnforcementModeAudit,
+		},
+		backend:       &dummySSHBackend{},
+		lock:          &sync.Mutex{},
+		logger:        log.NewTestLogger(t),
+		username:      "foo",
+		remoteAddress: net.ParseIP("127.0.0.1"),
+		limiter:       limiter,
+	}
+
+	first, err := ssh.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.Nil(t, err)
+	second, err := ssh.OnSessionChannel(1, []byte{}, &sessionChannel{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, limiter.userSessions["foo"])
+
+	first.OnClose()
+	second.OnClose()
+	assert.Equal(t, 0, limiter.userSessions["foo"])
+	assert.Equal(t, uint(0), ssh.sessionCount)
+}
+
+func TestInvalidEnforcement(t *testing.T) {
+	assert.Error(t, Config{Enforcement: "foo"}.Validate())
+	assert.Error(t, Config{Env: EnvConfig{Enforcement: "foo"}}.Validate())
+	assert.Error(t, Config{X11: X11Config{Enforcement: "foo"}}.Validate())
+	assert.Error(t, Config{AgentForwarding: AgentForwardingConfig{Enforcement: "foo"}}.Validate())
+	assert.Error(t, Config{Users: map[string]OverrideConfig{"foo": {Enforcement: "foo"}}}.Validate())
+	assert.NoError(t, Config{Enforcement: EnforcementModeAudit}.Validate())
+}
@@ -0,0 +1,222 @@
+package security
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/containerssh/log"
+)
+
+// EnvTemplateData is the data available to the templates in EnvConfig.Set, e.g. `{{ .Username }}`.
+type EnvTemplateData struct {
+	// Username is the authenticated username.
+	Username string
+	// Groups contains the groups of the user if a GroupResolver is configured.
+	Groups []string
+	// RemoteAddress is the IP address of the client. It is only known when using NewHandler.
+	RemoteAddress string
+	// RemotePort is the port of the client. It is only known when using NewHandler.
+	RemotePort int
+	// ConnectionID is the unique identifier of the connection. It is only known when using NewHandler.
+	ConnectionID string
+	// ChannelID is the identifier of the session channel.
+	ChannelID uint64
+}
+
+// parseEnvTemplate parses the template of an environment variable in EnvConfig.Set.
+func parseEnvTemplate(name string, value string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(value)
+}
+
+// validateEnvSet validates the names and templates of the injected environment variables.
+func validateEnvSet(set map[string]string) error {
+	for name, value := range set {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("invalid environment variable name: %q", name)
+		}
+		if _, err := parseEnvTemplate(name, value); err != nil {
+			return fmt.Errorf("invalid template for %s (%w)", name, err)
+		}
+	}
+	return nil
+}
+
+// EnvValueConfig constrains the values of the environment variables matching Name.
+type EnvValueConfig struct {
+	// Name selects the environment variables this entry applies to. It is matched exactly unless it is prefixed with
+	// PatternPrefixGlob or PatternPrefixRegex, e.g. `glob:*` for all variables.
+	Name string `json:"name" yaml:"name"`
+	// Allow only allows values matching one of the entries if set. Entries are matched exactly unless they are
+	// prefixed with PatternPrefixGlob or PatternPrefixRegex.
+	Allow []string `json:"allow" yaml:"allow"`
+	// MaxLength limits the length of the value in bytes. 0 means unlimited.
+	MaxLength int `json:"maxLength" yaml:"maxLength"`
+	// ForbidNUL rejects values containing a NUL character.
+	ForbidNUL bool `json:"forbidNUL" yaml:"forbidNUL"`
+	// ForbidNewlines rejects values containing a carriage return or line feed character.
+	ForbidNewlines bool `json:"forbidNewlines" yaml:"forbidNewlines"`
+}
+
+// Validate validates the environment variable value constraints.
+func (e EnvValueConfig) Validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("no name specified")
+	}
+	if err := validatePatterns([]string{e.Name}); err != nil {
+		return fmt.Errorf("invalid name (%w)", err)
+	}
+	if err := validatePatterns(e.Allow); err != nil {
+		return fmt.Errorf("invalid allow list (%w)", err)
+	}
+	if e.MaxLength < 0 {
+		return fmt.Errorf("invalid maxLength setting: %d", e.MaxLength)
+	}
+	return nil
+}
+
+// check returns the reason the value is rejected, or an empty string if it is allowed.
+func (e EnvValueConfig) check(parsed *parsedConfig, value string) string {
+	if e.MaxLength > 0 && len(value) > e.MaxLength {
+		return fmt.Sprintf("its value is longer than %d bytes", e.MaxLength)
+	}
+	if e.ForbidNUL && strings.ContainsRune(value, 0) {
+		return "its value contains a NUL character"
+	}
+	if e.ForbidNewlines && strings.ContainsAny(value, "\r\n") {
+		return "its value contains a newline character"
+	}
+	if len(e.Allow) > 0 {
+		if _, ok := parsed.matchAny(e.Allow, value); !ok {
+			return "its value does not match the allow list"
+		}
+	}
+	return ""
+}
+
+// checkEnvValue checks the value of an environment variable against all value constraints matching its name.
+func (c Config) checkEnvValue(decision Decision, name string, value string) (Decision, log.Message) {
+	for _, constraint := range c.Env.Values {
+		if _, ok := c.parsed.matchAny([]string{constraint.Name}, name); !ok {
+			continue
+		}
+		if reason := constraint.check(c.parsed, value); reason != "" {
+			decision.MatchedRule = constraint.Name
+			return decision, log.UserMessage(
+				EEnvRejected,
+				"Environment variable setting rejected.",
+				"Setting an environment variable is rejected because %s.",
+				reason,
+			).Label("name", name).Label("length", len(value))
+		}
+	}
+	return decision, nil
+}
+
+// checkEnvLimits checks the number and total size of the environment variables set in the session. It does not count
+// the variable, see countEnv.
+func (s *sessionHandler) checkEnvLimits(
+	config Config,
+	decision Decision,
+	name string,
+	value string,
+) (Decision, log.Message) {
+	size := len(name) + len(value)
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if config.Env.MaxVariables > 0 && s.envCount >= config.Env.MaxVariables {
+		decision.MatchedRule = "maxVariables"
+		return decision, log.UserMessage(
+			EEnvRejected,
+			"Environment variable setting rejected.",
+			"Setting an environment variable is rejected because the session has already set the maximum of %d "+
+				"environment variables.",
+			config.Env.MaxVariables,
+		).Label("name", name)
+	}
+	if config.Env.MaxTotalBytes > 0 && s.envBytes+size > config.Env.MaxTotalBytes {
+		decision.MatchedRule = "maxTotalBytes"
+		return decision, log.UserMessage(
+			EEnvRejected,
+			"Environment variable setting rejected.",
+			"Setting an environment variable is rejected because the environment variables of the session would "+
+				"exceed %d bytes.",
+			config.Env.MaxTotalBytes,
+		).Label("name", name)
+	}
+	return decision, nil
+}
+
+// countEnv counts a variable set on the backend towards the MaxVariables and MaxTotalBytes limits. Variables the policy
+// rejects are not counted even if they are passed on in audit mode, so the limits are evaluated as if the policy was
+// enforced.
+func (s *sessionHandler) countEnv(name string, value string) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.envCount++
+	s.envBytes += len(name) + len(value)
+}
+
+// envTemplateData returns the data for the templates in EnvConfig.Set.
+func (s *sessionHandler) envTemplateData() EnvTemplateData {
+	connection := s.sshConnection
+	connection.lock.Lock()
+	groups := connection.groups
+	connection.lock.Unlock()
+	return EnvTemplateData{
+		Username:      connection.username,
+		Groups:        groups,
+		RemoteAddress: ipString(connection.remoteAddress),
+		RemotePort:    connection.remotePort,
+		ConnectionID:  connection.connectionID,
+		ChannelID:     s.channelID,
+	}
+}
+
+// injectEnv sets the environment variables configured in EnvConfig.Set on the backend. It must be called before the
+// program is started.
+func (s *sessionHandler) injectEnv(requestID uint64, config Config) error {
+	if len(config.Env.Set) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(config.Env.Set))
+	for name := range config.Env.Set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data := s.envTemplateData()
+	for _, name := range names {
+		value, err := config.parsed.renderEnvTemplate(name, config.Env.Set[name], data)
+		if err == nil {
+			err = s.backend.OnEnvRequest(requestID, name, value)
+		}
+		if err != nil {
+			err := log.WrapUser(
+				err,
+				EFailedSetEnv,
+				"Could not execute program.",
+				"Program execution failed because the security layer could not set the %s variable.",
+				name,
+			)
+			s.sshConnection.addLabels(err)
+			s.logger.Error(err.Label("channelId", s.channelID))
+			return err
+		}
+	}
+	return nil
+}
+
+// renderEnvTemplate expands the template of an environment variable.
+func (p *parsedConfig) renderEnvTemplate(name string, value string, data EnvTemplateData) (string, error) {
+	tpl, err := p.envTemplate(name, value)
+	if err != nil {
+		return "", err
+	}
+	result := &bytes.Buffer{}
+	if err := tpl.Execute(result, data); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
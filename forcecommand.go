@@ -0,0 +1,186 @@
+package security
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/containerssh/log"
+)
+
+// ForceCommandTemplateData is the data available to forced command templates, e.g.
+// `/usr/bin/menu --user {{ quote .Username }}`. The `quote` function quotes a value for use as a single shell word.
+type ForceCommandTemplateData struct {
+	EnvTemplateData
+
+	// RequestType is the type of the request being replaced: exec, shell or subsystem.
+	RequestType string
+	// OriginalCommand is the command sent by the client in exec requests.
+	OriginalCommand string
+	// Subsystem is the subsystem name sent by the client in subsystem requests.
+	Subsystem string
+}
+
+// shellQuote quotes the value in single quotes so a shell treats it as a single word.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// parseForceCommand parses a forced command template.
+func parseForceCommand(value string) (*template.Template, error) {
+	return template.New("forceCommand").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"quote": shellQuote}).
+		Parse(value)
+}
+
+// renderForceCommand expands a forced command template.
+func (p *parsedConfig) renderForceCommand(value string, data ForceCommandTemplateData) (string, error) {
+	tpl, err := p.forceCommand(value)
+	if err != nil {
+		return "", err
+	}
+	result := &bytes.Buffer{}
+	if err := tpl.Execute(result, data); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// validateForceCommand checks that the forced command template parses.
+func validateForceCommand(value string) error {
+	if _, err := parseForceCommand(value); err != nil {
+		return fmt.Errorf("invalid forceCommand template (%w)", err)
+	}
+	return nil
+}
+
+// validateForceCommands validates the per-subsystem forced command templates.
+func validateForceCommands(forceCommands map[string]string) error {
+	for subsystem, value := range forceCommands {
+		if subsystem == "" {
+			return fmt.Errorf("empty subsystem name in forceCommands")
+		}
+		if err := validateForceCommand(value); err != nil {
+			return fmt.Errorf("invalid forced command for subsystem %s (%w)", subsystem, err)
+		}
+	}
+	return nil
+}
+
+// getForceCommand returns the forced command template for a program request, or an empty string if the request is
+// not forced. The rule is the command rule checkExec matched for an exec request, if any. The most specific setting
+// takes precedence: the matching command rule, the per-subsystem entry, then
+// the ForceCommand of the request's section, and finally the global ForceCommand. An empty per-subsystem entry exempts
+// the subsystem from the global ForceCommand.
+func (c Config) getForceCommand(requestType string, program string, rule *CommandRule) string {
+	switch requestType {
+	case "exec":
+		if rule != nil && rule.ForceCommand != "" {
+			return rule.ForceCommand
+		}
+		if c.Command.ForceCommand != "" {
+			return c.Command.ForceCommand
+		}
+	case "shell":
+		if c.Shell.ForceCommand != "" {
+			return c.Shell.ForceCommand
+		}
+	case "subsystem":
+		if forceCommand, ok := c.Subsystem.ForceCommands[program]; ok {
+			return forceCommand
+		}
+		if forceCommand, ok := c.Subsystem.ForceCommands["*"]; ok {
+			return forceCommand
+		}
+	}
+	return c.ForceCommand
+}
+
+// forceCommand returns the expanded forced command for a program request, or an empty string if the request is not
+// forced.
+func (s *sessionHandler) forceCommand(
+	config Config,
+	requestType string,
+	program string,
+	rule *CommandRule,
+) (string, log.Message) {
+	forceCommand := config.getForceCommand(requestType, program, rule)
+	if forceCommand == "" {
+		return "", nil
+	}
+	data := ForceCommandTemplateData{
+		EnvTemplateData: s.envTemplateData(),
+		RequestType:     requestType,
+	}
+	switch requestType {
+	case "exec":
+		data.OriginalCommand = program
+	case "subsystem":
+		data.Subsystem = program
+	}
+	command, err := config.parsed.renderForceCommand(forceCommand, data)
+	if err != nil {
+		err := log.WrapUser(
+			err,
+			EForceCommandFailed,
+			"Could not execute program.",
+			"Program execution failed because the forced command for the %s request could not be expanded.",
+			requestType,
+		)
+		s.sshConnection.addLabels(err)
+		s.logger.Error(err.Label("channelId", s.channelID))
+		return "", err
+	}
+	return command, nil
+}
+
+// applyForceCommand expands the forced command of a program request the policy allows and marks the decision as
+// rewritten. The rule is the command rule that allowed an exec request, if any. Rejected requests are not expanded. If
+// the forced command cannot be expanded the failure is recorded as a decision and always enforced, since running the
+// requested program instead would bypass the forced command.
+func (s *sessionHandler) applyForceCommand(
+	config Config,
+	decision Decision,
+	rejection log.Message,
+	requestType string,
+	program string,
+	rule *CommandRule,
+) (Decision, string, error) {
+	if rejection != nil {
+		return decision, "", nil
+	}
+	forceCommand, failure := s.forceCommand(config, requestType, program, rule)
+	if failure != nil {
+		decision.MatchedRule = "forceCommand"
+		_ = s.decide(config, EnforcementModeEnforce, decision, failure)
+		return decision, "", failure
+	}
+	return s.rewrite(decision, nil, forceCommand), forceCommand, nil
+}
+
+// execForceCommand executes the forced command instead of the program requested by the client. For exec and subsystem
+// requests the original program is passed in the `SSH_ORIGINAL_COMMAND` environment variable.
+func (s *sessionHandler) execForceCommand(requestID uint64, requestType string, program string, command string) error {
+	if requestType != "shell" {
+		if err := s.backend.OnEnvRequest(requestID, "SSH_ORIGINAL_COMMAND", program); err != nil {
+			err := log.WrapUser(
+				err,
+				EFailedSetEnv,
+				"Could not execute program.",
+				"Command execution failed because the security layer could not set the SSH_ORIGINAL_COMMAND variable.",
+			)
+			s.logger.Error(err)
+			return err
+		}
+	}
+	message := log.NewMessage(
+		MForcingCommand,
+		"Forcing command execution to %s",
+		command,
+	)
+	s.sshConnection.addLabels(message)
+	s.logger.Info(message.Label("channelId", s.channelID))
+	return s.backend.OnExecRequest(requestID, command)
+}
@@ -0,0 +1,176 @@
+package security
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newForceCommandTestSession(t *testing.T, config Config) (*sessionHandler, *dummyBackend) {
+	backend := &dummyBackend{env: map[string]string{}}
+	session := &sessionHandler{
+		config:    config,
+		backend:   backend,
+		channelID: 3,
+		sshConnection: &sshConnectionHandler{
+			lock:          &sync.Mutex{},
+			username:      "foo",
+			remoteAddress: net.ParseIP("192.0.2.1"),
+		},
+		logger: log.NewTestLogger(t),
+	}
+	return session, backend
+}
+
+func TestForceCommandTemplate(t *testing.T) {
+	session, backend := newForceCommandTestSession(t, Config{
+		Command: CommandConfig{Mode: ExecutionPolicyEnable},
+		ForceCommand: "/bin/wrapper --user {{ quote .Username }} --from {{ .RemoteAddress }} " +
+			"--{{ .RequestType }} {{ quote .OriginalCommand }}",
+	})
+	assert.NoError(t, session.OnExecRequest(1, "echo 'hello world'"))
+	assert.Equal(
+		t,
+		[]string{`/bin/wrapper --user 'foo' --from 192.0.2.1 --exec 'echo '\''hello world'\'''`},
+		backend.commandsExecuted,
+	)
+	assert.Equal(t, "echo 'hello world'", backend.env["SSH_ORIGINAL_COMMAND"])
+
+	session, backend = newForceCommandTestSession(t, Config{ForceCommand: `/bin/awk '{{ "{{" }}print}'`})
+	assert.NoError(t, session.OnShell(1))
+	assert.Equal(t, []string{`/bin/awk '{{print}'`}, backend.commandsExecuted)
+}
+
+func TestForceCommandPrecedence(t *testing.T) {
+	config := Config{
+		ForceCommand: "/bin/global",
+		Command: CommandConfig{
+			Mode:         ExecutionPolicyFilter,
+			Allow:        []string{"/bin/ls"},
+			ForceCommand: "/bin/command",
+			Rules: []CommandRule{
+				{Program: "rsync", RemainingArgs: "glob:*", ForceCommand: "/bin/rrsync {{ quote .OriginalCommand }}"},
+			},
+		},
+		Shell: ShellConfig{Mode: ExecutionPolicyEnable, ForceCommand: "/bin/menu"},
+		Subsystem: SubsystemConfig{
+			Mode: ExecutionPolicyEnable,
+			ForceCommands: map[string]string{
+				"sftp":    "/usr/lib/sftp-wrapper {{ quote .Subsystem }}",
+				"netconf": "",
+			},
+		},
+	}
+
+	session, backend := newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnExecRequest(1, "rsync --server ."))
+	assert.Equal(t, []string{"/bin/rrsync 'rsync --server .'"}, backend.commandsExecuted)
+
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnExecRequest(1, "/bin/ls"))
+	assert.Equal(t, []string{"/bin/command"}, backend.commandsExecuted)
+
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnShell(1))
+	assert.Equal(t, []string{"/bin/menu"}, backend.commandsExecuted)
+	assert.Equal(t, map[string]string{}, backend.env)
+
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnSubsystem(1, "sftp"))
+	assert.Equal(t, []string{"/usr/lib/sftp-wrapper 'sftp'"}, backend.commandsExecuted)
+	assert.Equal(t, "sftp", backend.env["SSH_ORIGINAL_COMMAND"])
+
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnSubsystem(1, "netconf"))
+	assert.Equal(t, []string{"netconf"}, backend.commandsExecuted)
+
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnSubsystem(1, "other"))
+	assert.Equal(t, []string{"/bin/global"}, backend.commandsExecuted)
+
+	config.Subsystem.ForceCommands["*"] = "/bin/subsystem"
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnSubsystem(1, "other"))
+	assert.Equal(t, []string{"/bin/subsystem"}, backend.commandsExecuted)
+}
+
+func TestForceCommandUserOverride(t *testing.T) {
+	menu := "/bin/menu {{ quote .Username }}"
+	config := Config{Shell: ShellConfig{Mode: ExecutionPolicyEnable}}
+	override := OverrideConfig{ForceCommand: &menu}
+	assert.NoError(t, override.Validate())
+	override.applyTo(&config)
+
+	session, backend := newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnShell(1))
+	assert.Equal(t, []string{"/bin/menu 'foo'"}, backend.commandsExecuted)
+}
+
+func TestForceCommandDecision(t *testing.T) {
+	session, _ := newForceCommandTestSession(t, Config{
+		Shell: ShellConfig{Mode: ExecutionPolicyEnable, ForceCommand: "/bin/menu {{ quote .Username }}"},
+	})
+	recorder := NewMemoryDecisionRecorder()
+	WithDecisionRecorder(recorder)(&session.sshConnection.options)
+	assert.NoError(t, session.OnShell(1))
+	decisions := recorder.Decisions()
+	assert.Len(t, decisions, 1)
+	assert.Equal(t, DecisionOutcomeRewrite, decisions[0].Outcome)
+	assert.Equal(t, "/bin/menu 'foo'", decisions[0].Rewrite)
+}
+
+func TestForceCommandExpansionFailure(t *testing.T) {
+	config := Config{
+		Shell:        ShellConfig{Mode: ExecutionPolicyEnable},
+		Enforcement:  EnforcementModeAudit,
+		ForceCommand: "/bin/menu {{ index .Groups 0 }}",
+	}
+	session, backend := newForceCommandTestSession(t, config)
+	recorder := NewMemoryDecisionRecorder()
+	WithDecisionRecorder(recorder)(&session.sshConnection.options)
+	err := session.OnShell(1)
+	assert.Error(t, err)
+	assert.Equal(t, EForceCommandFailed, err.(log.Message).Code())
+	assert.Empty(t, backend.commandsExecuted)
+	decisions := recorder.Decisions()
+	assert.Len(t, decisions, 1)
+	assert.Equal(t, DecisionOutcomeDeny, decisions[0].Outcome)
+	assert.Equal(t, EForceCommandFailed, decisions[0].Code)
+
+	// Rejected requests are not expanded, the client gets the rejection.
+	config.Enforcement = EnforcementModeEnforce
+	config.Shell.Mode = ExecutionPolicyDisable
+	session, backend = newForceCommandTestSession(t, config)
+	err = session.OnShell(1)
+	assert.Error(t, err)
+	assert.Equal(t, EShellRejected, err.(log.Message).Code())
+	assert.Empty(t, backend.commandsExecuted)
+}
+
+func TestForceCommandTemplatesAreParsedOnce(t *testing.T) {
+	menu := "/bin/menu {{ quote .Username }}"
+	config := Config{Users: map[string]OverrideConfig{"alice": {ForceCommand: &menu}}}.parse()
+	parsed, ok := config.parsed.forceCommands[menu]
+	assert.True(t, ok)
+	tpl, err := config.parsed.forceCommand(menu)
+	assert.NoError(t, err)
+	assert.Same(t, parsed, tpl)
+}
+
+func TestForceCommandValidation(t *testing.T) {
+	assert.NoError(t, Config{ForceCommand: "/bin/menu {{ .Username }}"}.Validate())
+	assert.Error(t, Config{ForceCommand: "/bin/menu {{ .Username"}.Validate())
+	assert.Error(t, Config{ForceCommand: "/bin/menu {{ unknown .Username }}"}.Validate())
+	assert.Error(t, Config{Shell: ShellConfig{ForceCommand: "{{"}}.Validate())
+	assert.Error(t, Config{Command: CommandConfig{ForceCommand: "{{"}}.Validate())
+	assert.Error(t, Config{Command: CommandConfig{
+		Rules: []CommandRule{{Program: "rsync", ForceCommand: "{{"}},
+	}}.Validate())
+	assert.Error(t, Config{Subsystem: SubsystemConfig{ForceCommands: map[string]string{"sftp": "{{"}}}.Validate())
+	assert.Error(t, Config{Subsystem: SubsystemConfig{ForceCommands: map[string]string{"": "/bin/true"}}}.Validate())
+	invalid := "{{"
+	assert.Error(t, OverrideConfig{ForceCommand: &invalid}.Validate())
+}
@@ -0,0 +1,237 @@
+package security
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ForwardingConfig controls TCP port forwarding requests.
+type ForwardingConfig struct {
+	// Local controls local port forwarding (`ssh -L`), which the client requests by opening `direct-tcpip` channels.
+	Local TCPForwardingConfig `json:"local" yaml:"local"`
+	// Remote controls remote port forwarding (`ssh -R`), which the client requests using the `tcpip-forward` and
+	// `cancel-tcpip-forward` global requests.
+	Remote TCPForwardingConfig `json:"remote" yaml:"remote"`
+}
+
+// Validate validates the forwarding configuration.
+func (f ForwardingConfig) Validate() error {
+	if err := f.Local.Validate(); err != nil {
+		return fmt.Errorf("invalid local forwarding configuration (%w)", err)
+	}
+	if err := f.Remote.Validate(); err != nil {
+		return fmt.Errorf("invalid remote forwarding configuration (%w)", err)
+	}
+	return nil
+}
+
+// TCPForwardingConfig controls one direction of TCP port forwarding.
+//
+// Allow and deny list entries have the format `host:port`. The host may be an IP address, a CIDR block, a hostname,
+// or `*` for any host. Hostnames are matched exactly unless they are prefixed with PatternPrefixGlob or
+// PatternPrefixRegex. IPv6 addresses and blocks must be enclosed in square brackets, e.g. `[fd00::/8]:22`. The port may
+// be a single port, a range such as `8000-8100`, or `*` for any port.
+//
+// The security layer does not resolve hostnames, so IP address and CIDR block entries only match destinations sent as
+// an IP address. In filter mode these allow list entries never match a hostname. Since a hostname such as `localhost`
+// may resolve to a denied address, destinations sent as a hostname are rejected if the deny list contains an IP
+// address or CIDR block entry for the port.
+//
+// The sshserver library answers forwarding requests on its own, so a rejected request is logged and recorded with the
+// rejection reason, but the client only receives the generic failure response of the library.
+type TCPForwardingConfig struct {
+	// Mode configures how to treat forwarding requests by SSH clients.
+	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Allow takes effect when Mode is ExecutionPolicyFilter and only allows forwarding to or from the specified
+	// destinations.
+	Allow []string `json:"allow" yaml:"allow"`
+	// Deny takes effect when Mode is not ExecutionPolicyDisable and rejects forwarding to or from the specified
+	// destinations.
+	Deny []string `json:"deny" yaml:"deny"`
+}
+
+// Validate validates the TCP forwarding configuration.
+func (t TCPForwardingConfig) Validate() error {
+	if err := t.Mode.Validate(); err != nil {
+		return fmt.Errorf("invalid mode (%w)", err)
+	}
+	for i, rule := range t.Allow {
+		if _, err := parseForwardingRule(rule); err != nil {
+			return fmt.Errorf("invalid allow list entry %d (%w)", i, err)
+		}
+	}
+	for i, rule := range t.Deny {
+		if _, err := parseForwardingRule(rule); err != nil {
+			return fmt.Errorf("invalid deny list entry %d (%w)", i, err)
+		}
+	}
+	return nil
+}
+
+// check evaluates a forwarding request against the policy. It returns the list entry that decided the outcome, if any,
+// and the reason of the rejection, or an empty string if the request is allowed.
+func (t TCPForwardingConfig) check(
+	parsed *parsedConfig,
+	mode ExecutionPolicy,
+	host string,
+	port uint32,
+) (rule string, reason string) {
+	switch mode {
+	case ExecutionPolicyDisable:
+		return "", "Port forwarding is disabled in the security settings."
+	case ExecutionPolicyFilter:
+		var ok bool
+		if rule, ok = parsed.matchForwardingRules(t.Allow, host, port); !ok {
+			return "", "The forwarding destination does not match the allow list."
+		}
+		fallthrough
+	default:
+		if denied, ok := parsed.matchForwardingRules(t.Deny, host, port); ok {
+			return denied, "The forwarding destination matches the deny list."
+		}
+		if net.ParseIP(host) == nil {
+			if denied, ok := parsed.matchNetworkForwardingRules(t.Deny, port); ok {
+				return denied, "The forwarding destination is a hostname and the deny list contains IP addresses."
+			}
+		}
+	}
+	return rule, ""
+}
+
+// forwardingRule is a parsed host:port allow or deny list entry.
+type forwardingRule struct {
+	anyHost  bool
+	network  *net.IPNet
+	host     matcher
+	portFrom uint32
+	portTo   uint32
+}
+
+func parseForwardingRule(rule string) (forwardingRule, error) {
+	result := forwardingRule{}
+	separator := strings.LastIndex(rule, ":")
+	if separator < 0 {
+		return result, fmt.Errorf("missing port in %q", rule)
+	}
+	host := rule[:separator]
+	port := rule[separator+1:]
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+
+	switch {
+	case host == "*":
+		result.anyHost = true
+	case net.ParseIP(host) != nil || strings.Contains(host, "/"):
+		network, err := parseNetwork(host)
+		if err != nil {
+			return result, fmt.Errorf("invalid host in %q (%w)", rule, err)
+		}
+		result.network = network
+	case host == "":
+		return result, fmt.Errorf("missing host in %q", rule)
+	default:
+		m, err := compilePattern(host)
+		if err != nil {
+			return result, fmt.Errorf("invalid host in %q (%w)", rule, err)
+		}
+		result.host = m
+	}
+
+	switch {
+	case port == "*":
+		result.portFrom = 0
+		result.portTo = 65535
+	case strings.Contains(port, "-"):
+		parts := strings.SplitN(port, "-", 2)
+		from, err := parsePort(parts[0])
+		if err != nil {
+			return result, fmt.Errorf("invalid port range in %q (%w)", rule, err)
+		}
+		to, err := parsePort(parts[1])
+		if err != nil {
+			return result, fmt.Errorf("invalid port range in %q (%w)", rule, err)
+		}
+		if from > to {
+			return result, fmt.Errorf("invalid port range in %q: %d is larger than %d", rule, from, to)
+		}
+		result.portFrom = from
+		result.portTo = to
+	default:
+		p, err := parsePort(port)
+		if err != nil {
+			return result, fmt.Errorf("invalid port in %q (%w)", rule, err)
+		}
+		result.portFrom = p
+		result.portTo = p
+	}
+	return result, nil
+}
+
+func parsePort(port string) (uint32, error) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(p), nil
+}
+
+func (r forwardingRule) matches(host string, port uint32) bool {
+	if port < r.portFrom || port > r.portTo {
+		return false
+	}
+	switch {
+	case r.anyHost:
+		return true
+	case r.network != nil:
+		ip := net.ParseIP(host)
+		return ip != nil && r.network.Contains(ip)
+	default:
+		return r.host(host)
+	}
+}
+
+// matchForwardingRules returns the first rule that matches the host and port. Invalid rules never match.
+func (p *parsedConfig) matchForwardingRules(rules []string, host string, port uint32) (string, bool) {
+	for _, rule := range rules {
+		parsed, err := p.forwardingRule(rule)
+		if err != nil {
+			continue
+		}
+		if parsed.matches(host, port) {
+			return rule, true
+		}
+	}
+	return "", false
+}
+
+// matchNetworkForwardingRules returns the first IP address or CIDR block rule that applies to the port.
+func (p *parsedConfig) matchNetworkForwardingRules(rules []string, port uint32) (string, bool) {
+	for _, rule := range rules {
+		parsed, err := p.forwardingRule(rule)
+		if err != nil {
+			continue
+		}
+		if parsed.network != nil && port >= parsed.portFrom && port <= parsed.portTo {
+			return rule, true
+		}
+	}
+	return "", false
+}
+
+// directTCPIPPayload is the extra data of a direct-tcpip channel as described in RFC 4254 section 7.2.
+type directTCPIPPayload struct {
+	HostToConnect  string
+	PortToConnect  uint32
+	OriginatorIP   string
+	OriginatorPort uint32
+}
+
+// tcpipForwardPayload is the payload of the tcpip-forward and cancel-tcpip-forward global requests as described in
+// RFC 4254 section 7.1.
+type tcpipForwardPayload struct {
+	AddressToBind string
+	PortToBind    uint32
+}
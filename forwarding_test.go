@@ -0,0 +1,141 @@
+package security
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestForwardingRules(t *testing.T) {
+	rules := []string{
+		"127.0.0.1:22",
+		"10.0.0.0/8:8000-8100",
+		"[fd00::/8]:443",
+		"glob:*.example.com:*",
+		"*:9000",
+	}
+	for _, rule := range rules {
+		_, err := parseForwardingRule(rule)
+		assert.NoError(t, err, rule)
+	}
+
+	for destination, expected := range map[struct {
+		host string
+		port uint32
+	}]string{
+		{"127.0.0.1", 22}:         "127.0.0.1:22",
+		{"127.0.0.1", 23}:         "",
+		{"10.1.2.3", 8050}:        "10.0.0.0/8:8000-8100",
+		{"10.1.2.3", 8101}:        "",
+		{"fd00::1", 443}:          "[fd00::/8]:443",
+		{"fe80::1", 443}:          "",
+		{"www.example.com", 1234}: "glob:*.example.com:*",
+		{"example.com", 1234}:     "",
+		{"anything", 9000}:        "*:9000",
+	} {
+		var parsed *parsedConfig
+		matched, _ := parsed.matchForwardingRules(rules, destination.host, destination.port)
+		assert.Equal(t, expected, matched, destination)
+	}
+
+	for _, rule := range []string{"localhost", ":22", "host:65536", "host:9-1", "10.0.0.0/33:22", "regex:(:22"} {
+		_, err := parseForwardingRule(rule)
+		assert.Error(t, err, rule)
+	}
+}
+
+func TestForwardingRulesAreParsedOnce(t *testing.T) {
+	rule := "192.0.2.0/24:2222"
+	config := Config{
+		Forwarding: ForwardingConfig{
+			Local: TCPForwardingConfig{Mode: ExecutionPolicyFilter, Allow: []string{rule, "host:65536"}},
+		},
+	}.parse()
+	parsed, ok := config.parsed.forwardingRules[rule]
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2222), parsed.portFrom)
+	_, ok = config.parsed.forwardingRules["host:65536"]
+	assert.False(t, ok)
+}
+
+func TestForwardingHostnamesAndIPRules(t *testing.T) {
+	var parsed *parsedConfig
+	deny := TCPForwardingConfig{Mode: ExecutionPolicyEnable, Deny: []string{"127.0.0.0/8:*", "192.0.2.0/24:22"}}
+	for destination, expected := range map[struct {
+		host string
+		port uint32
+	}]string{
+		{"127.0.0.1", 22}:        "127.0.0.0/8:*",
+		{"localhost", 22}:        "127.0.0.0/8:*",
+		{"localhost", 8080}:      "127.0.0.0/8:*",
+		{"198.51.100.1", 22}:     "",
+		{"www.example.com", 443}: "127.0.0.0/8:*",
+	} {
+		rule, reason := deny.check(parsed, deny.Mode, destination.host, destination.port)
+		assert.Equal(t, expected, rule, destination)
+		assert.Equal(t, expected != "", reason != "", destination)
+	}
+
+	deny.Deny = []string{"192.0.2.0/24:22"}
+	_, reason := deny.check(parsed, deny.Mode, "www.example.com", 443)
+	assert.Empty(t, reason)
+	_, reason = deny.check(parsed, deny.Mode, "www.example.com", 22)
+	assert.NotEmpty(t, reason)
+
+	allow := TCPForwardingConfig{Mode: ExecutionPolicyFilter, Allow: []string{"127.0.0.0/8:*"}}
+	_, reason = allow.check(parsed, allow.Mode, "127.0.0.1", 22)
+	assert.Empty(t, reason)
+	_, reason = allow.check(parsed, allow.Mode, "localhost", 22)
+	assert.NotEmpty(t, reason)
+}
+
+func TestForwardingPolicy(t *testing.T) {
+	backend := &dummySSHBackend{}
+	connection := &sshConnectionHandler{
+		config: Config{
+			Forwarding: ForwardingConfig{
+				Local: TCPForwardingConfig{
+					Mode:  ExecutionPolicyFilter,
+					Allow: []string{"127.0.0.1:8080"},
+				},
+				Remote: TCPForwardingConfig{
+					Mode: ExecutionPolicyEnable,
+					Deny: []string{"0.0.0.0:*"},
+				},
+			},
+		},
+		backend: backend,
+		lock:    &sync.Mutex{},
+		logger:  log.NewTestLogger(t),
+	}
+
+	allowed := ssh.Marshal(directTCPIPPayload{"127.0.0.1", 8080, "127.0.0.1", 50000})
+	denied := ssh.Marshal(directTCPIPPayload{"127.0.0.1", 22, "127.0.0.1", 50000})
+	decision, rejection := connection.checkLocalForwarding(allowed)
+	assert.Nil(t, rejection)
+	assert.Equal(t, "127.0.0.1:8080", decision.MatchedRule)
+	assert.Equal(t, "127.0.0.1:8080", decision.Subject)
+	_, rejection = connection.checkLocalForwarding(denied)
+	assert.NotNil(t, rejection)
+	assert.Equal(t, ssh.Prohibited, rejection.Reason())
+	assert.Equal(t, ELocalForwardingRejected, rejection.Code())
+	_, rejection = connection.checkLocalForwarding([]byte{1})
+	assert.Equal(t, ssh.ConnectionFailed, rejection.Reason())
+
+	connection.OnUnsupportedChannel(1, "direct-tcpip", allowed)
+	connection.OnUnsupportedChannel(2, "direct-tcpip", denied)
+	assert.Equal(t, []string{"direct-tcpip"}, backend.unsupportedChannels)
+
+	connection.OnUnsupportedGlobalRequest(1, "tcpip-forward", ssh.Marshal(tcpipForwardPayload{"127.0.0.1", 8080}))
+	connection.OnUnsupportedGlobalRequest(2, "tcpip-forward", ssh.Marshal(tcpipForwardPayload{"0.0.0.0", 8080}))
+	connection.OnUnsupportedGlobalRequest(3, "cancel-tcpip-forward", ssh.Marshal(tcpipForwardPayload{"127.0.0.1", 8080}))
+	assert.Equal(t, []string{"tcpip-forward", "cancel-tcpip-forward"}, backend.globalRequests)
+
+	connection.config.DefaultMode = ExecutionPolicyDisable
+	connection.config.Forwarding.Remote.Mode = ExecutionPolicyUnconfigured
+	_, rejection = connection.checkRemoteForwarding("tcpip-forward", ssh.Marshal(tcpipForwardPayload{"127.0.0.1", 80}))
+	assert.NotNil(t, rejection)
+}
@@ -6,7 +6,7 @@ require (
 	github.com/containerssh/log v1.0.0
 	github.com/containerssh/sshserver v1.0.0
 	github.com/imdario/mergo v0.3.12 // indirect
-	github.com/mattn/go-shellwords v1.0.11 // indirect
+	github.com/mattn/go-shellwords v1.0.11
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
 )
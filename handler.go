@@ -0,0 +1,86 @@
+package security
+
+import (
+	"context"
+	"net"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/sshserver"
+)
+
+type handler struct {
+	config  Config
+	backend sshserver.Handler
+	logger  log.Logger
+	options options
+	limiter *Limiter
+}
+
+func (h *handler) OnReady() error {
+	return h.backend.OnReady()
+}
+
+func (h *handler) OnShutdown(shutdownContext context.Context) {
+	h.backend.OnShutdown(shutdownContext)
+}
+
+func (h *handler) OnNetworkConnection(client net.TCPAddr, connectionID string) (
+	sshserver.NetworkConnectionHandler,
+	error,
+) {
+	config := h.options.currentConfig(h.config)
+	ip := client.IP.String()
+	decision, rejection := h.checkConnection(config, client.IP)
+	decision.ConnectionID = connectionID
+	decision.RemoteAddress = ip
+	if rejection != nil {
+		rejection.Label("remoteAddr", ip).Label("connectionId", connectionID)
+	}
+	if h.options.decide(h.logger, config.getEnforcement(EnforcementModeUnconfigured), decision, rejection) {
+		return nil, rejection
+	}
+	if rejection != nil {
+		// In audit mode the connection still takes a slot so the release on disconnect stays balanced.
+		h.limiter.acquire(h.limiter.ipConnections, ip, 0)
+	}
+	backend, err := h.backend.OnNetworkConnection(client, connectionID)
+	if err != nil {
+		h.limiter.release(h.limiter.ipConnections, ip)
+		return nil, err
+	}
+	return &networkHandler{
+		config:                config,
+		backend:               backend,
+		logger:                h.logger,
+		options:               h.options,
+		limiter:               h.limiter,
+		remoteAddress:         client.IP,
+		remotePort:            client.Port,
+		connectionID:          connectionID,
+		holdsIPConnectionSlot: true,
+	}, nil
+}
+
+// checkConnection evaluates the network policy and the per-IP connection limit. If the connection is allowed it takes
+// a connection slot for the IP address.
+func (h *handler) checkConnection(config Config, ip net.IP) (Decision, log.Message) {
+	decision := Decision{RequestType: "connection", Subject: ip.String(), Mode: config.Network.Mode}
+	rule, reason := config.Network.checkConnection(config.parsed, ip)
+	decision.MatchedRule = rule
+	if reason != "" {
+		return decision, log.UserMessage(
+			EConnectionRejected,
+			"Connection rejected.",
+			"%s",
+			reason,
+		)
+	}
+	if !h.limiter.acquire(h.limiter.ipConnections, ip.String(), config.Limits.MaxConnectionsPerIP) {
+		return decision, log.UserMessage(
+			EMaxIPConnections,
+			"Too many connections.",
+			"The client IP address has too many open connections.",
+		)
+	}
+	return decision, nil
+}
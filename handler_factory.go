@@ -7,19 +7,59 @@ import (
 	"github.com/containerssh/sshserver"
 )
 
-// New creates a new security backend proxy.
+// New creates a new security backend proxy. If the per-user Limits are set, the same Limiter must be passed to every
+// call using WithLimiter so the limits are enforced across connections, otherwise New returns an error. If
+// WithConfigHolder is passed, config is ignored in favor of the configuration of the holder.
 //goland:noinspection GoUnusedExportedFunction
 func New(
 	config Config,
 	backend sshserver.NetworkConnectionHandler,
 	logger log.Logger,
+	opts ...Option,
 ) (sshserver.NetworkConnectionHandler, error) {
+	options := newOptions(opts)
+	config = options.currentConfig(config)
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid security configuration (%w)", err)
 	}
+	if config.Limits.hasUserLimits() && options.limiter == nil {
+		return nil, fmt.Errorf("the per-user limits require a shared Limiter passed using WithLimiter")
+	}
+	if options.configHolder == nil {
+		config = config.parse()
+	}
 	return &networkHandler{
 		config:  config,
 		backend: backend,
 		logger:  logger,
+		options: options,
+		limiter: options.getLimiter(),
+	}, nil
+}
+
+// NewHandler creates a new security proxy wrapping the top level sshserver.Handler. Compared to New this allows the
+// security layer to see the client address and connection ID, enabling the Network configuration and the Sources
+// overrides. If WithConfigHolder is passed, config is ignored in favor of the configuration of the holder.
+//goland:noinspection GoUnusedExportedFunction
+func NewHandler(
+	config Config,
+	backend sshserver.Handler,
+	logger log.Logger,
+	opts ...Option,
+) (sshserver.Handler, error) {
+	options := newOptions(opts)
+	config = options.currentConfig(config)
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid security configuration (%w)", err)
+	}
+	if options.configHolder == nil {
+		config = config.parse()
+	}
+	return &handler{
+		config:  config,
+		backend: backend,
+		logger:  logger,
+		options: options,
+		limiter: options.getLimiter(),
 	}, nil
 }
@@ -2,6 +2,7 @@ package security
 
 import (
 	"context"
+	"net"
 	"sync"
 
 	"github.com/containerssh/log"
@@ -12,6 +13,25 @@ type networkHandler struct {
 	config  Config
 	backend sshserver.NetworkConnectionHandler
 	logger  log.Logger
+	options options
+	// remoteAddress is the client address. It is only known if the network handler was created by NewHandler.
+	remoteAddress net.IP
+	// remotePort is the client port. It is only known if the network handler was created by NewHandler.
+	remotePort int
+	// connectionID is the unique identifier of the connection. It is only known if the network handler was created
+	// by NewHandler.
+	connectionID string
+	// limiter is shared between all connections of the handler returned by NewHandler, and between all handlers
+	// created by New with the same WithLimiter option.
+	limiter *Limiter
+	// holdsIPConnectionSlot is true if a connection slot for the remote address must be released on disconnect.
+	holdsIPConnectionSlot bool
+	// username is set when a connection slot for the user must be released on disconnect.
+	username string
+	// sshConnection is set after a successful handshake so it can be removed from the session registries on
+	// disconnect.
+	sshConnection  *sshConnectionHandler
+	disconnectOnce sync.Once
 }
 
 func (n *networkHandler) OnAuthKeyboardInteractive(
@@ -50,18 +70,123 @@ func (n *networkHandler) OnHandshakeSuccess(username string) (
 	connection sshserver.SSHConnectionHandler,
 	failureReason error,
 ) {
+	base := n.options.currentConfig(n.config)
+	groups, groupsResolved, err := n.resolveGroups(base, username)
+	if err != nil {
+		return nil, err
+	}
+	config := n.resolveConfig(base, username, groups)
+	decision := Decision{
+		RequestType:   "handshake",
+		Subject:       username,
+		Username:      username,
+		ConnectionID:  n.connectionID,
+		RemoteAddress: ipString(n.remoteAddress),
+	}
+	var rejection log.Message
+	if !n.limiter.acquire(n.limiter.userConnections, username, base.Limits.MaxConnectionsPerUser) {
+		rejection = log.UserMessage(
+			EMaxUserConnections,
+			"Too many connections.",
+			"User %s has too many open connections.",
+			username,
+		).Label("username", username)
+		n.addLabels(rejection)
+	}
+	if n.options.decide(n.logger, config.getEnforcement(EnforcementModeUnconfigured), decision, rejection) {
+		return nil, rejection
+	}
+	if rejection != nil {
+		// In audit mode the connection still takes a slot so the release on disconnect stays balanced.
+		n.limiter.acquire(n.limiter.userConnections, username, 0)
+	}
 	backend, failureReason := n.backend.OnHandshakeSuccess(username)
 	if failureReason != nil {
+		n.limiter.release(n.limiter.userConnections, username)
 		return nil, failureReason
 	}
-	return &sshConnectionHandler{
-		config:  n.config,
-		backend: backend,
-		lock:    &sync.Mutex{},
-		logger:  n.logger,
-	}, nil
+	n.username = username
+	sshConnection := &sshConnectionHandler{
+		config:         config,
+		backend:        backend,
+		lock:           &sync.Mutex{},
+		logger:         n.logger,
+		username:       username,
+		groups:         groups,
+		groupsResolved: groupsResolved,
+		connectionID:   n.connectionID,
+		remoteAddress:  n.remoteAddress,
+		remotePort:     n.remotePort,
+		limiter:        n.limiter,
+		options:        n.options,
+	}
+	for _, registry := range n.options.sessionRegistries() {
+		registry.add(sshConnection)
+	}
+	n.sshConnection = sshConnection
+	return sshConnection, nil
+}
+
+// resolveGroups looks up the groups of the user if the configuration contains group overrides. The second return
+// value indicates whether the groups were looked up.
+func (n *networkHandler) resolveGroups(config Config, username string) ([]string, bool, error) {
+	groups, resolved, err := n.options.resolveGroups(config, username)
+	if err != nil {
+		err := log.WrapUser(
+			err,
+			EGroupResolutionFailed,
+			"Could not determine the security policy for your user.",
+			"Failed to resolve the groups of user %s.",
+			username,
+		).Label("username", username)
+		n.addLabels(err)
+		n.logger.Error(err)
+		return nil, false, err
+	}
+	return groups, resolved, nil
+}
+
+// resolveConfig applies the user, group and source overrides for the authenticated user.
+func (n *networkHandler) resolveConfig(config Config, username string, groups []string) Config {
+	if len(config.Users) == 0 && len(config.Groups) == 0 && len(config.Sources) == 0 {
+		return config
+	}
+	config, applied := config.resolve(username, groups, n.remoteAddress)
+	if len(applied) > 0 {
+		n.logger.Debug(
+			log.NewMessage(
+				MOverridesApplied,
+				"Applying policy overrides for user %s.",
+				username,
+			).Label("username", username).Label("groups", groups).Label("overrides", applied),
+		)
+	}
+	return config
+}
+
+// addLabels adds the connection details known to the security layer to a message.
+func (n *networkHandler) addLabels(message log.Message) {
+	if n.connectionID != "" {
+		message.Label("connectionId", n.connectionID)
+	}
+	if n.remoteAddress != nil {
+		message.Label("remoteAddr", n.remoteAddress.String())
+	}
 }
 
 func (n *networkHandler) OnDisconnect() {
+	n.disconnectOnce.Do(func() {
+		if n.sshConnection != nil {
+			for _, registry := range n.options.sessionRegistries() {
+				registry.remove(n.sshConnection)
+			}
+		}
+		if n.username != "" {
+			n.limiter.release(n.limiter.userConnections, n.username)
+		}
+		if n.holdsIPConnectionSlot {
+			n.limiter.release(n.limiter.ipConnections, n.remoteAddress.String())
+		}
+	})
 	n.backend.OnDisconnect()
 }
@@ -2,7 +2,9 @@ package security
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"runtime"
 	"sync"
 	"testing"
 
@@ -38,6 +40,141 @@ func TestMaxSessions(t *testing.T) {
 	}
 }
 
+func TestMaxSessionsReleasedOnClose(t *testing.T) {
+	ssh := &sshConnectionHandler{
+		config: Config{
+			MaxSessions: 1,
+		},
+		backend: &dummySSHBackend{},
+		lock:    &sync.Mutex{},
+		logger:  log.NewTestLogger(t),
+	}
+
+	handler, err := ssh.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.Nil(t, err)
+	_, err = ssh.OnSessionChannel(1, []byte{}, &sessionChannel{})
+	assert.NotNil(t, err)
+	handler.OnClose()
+	// Closing twice must not free up an additional slot.
+	handler.OnClose()
+	handler, err = ssh.OnSessionChannel(2, []byte{}, &sessionChannel{})
+	assert.Nil(t, err)
+	_, err = ssh.OnSessionChannel(3, []byte{}, &sessionChannel{})
+	assert.NotNil(t, err)
+}
+
+func TestMaxTotalSessions(t *testing.T) {
+	ssh := &sshConnectionHandler{
+		config: Config{
+			MaxSessions:      -1,
+			MaxTotalSessions: 3,
+		},
+		backend: &dummySSHBackend{},
+		lock:    &sync.Mutex{},
+		logger:  log.NewTestLogger(t),
+	}
+
+	for i := 0; i < 3; i++ {
+		handler, err := ssh.OnSessionChannel(uint64(i), []byte{}, &sessionChannel{})
+		assert.Nil(t, err)
+		handler.OnClose()
+	}
+	_, err := ssh.OnSessionChannel(3, []byte{}, &sessionChannel{})
+	assert.NotNil(t, err)
+	assert.Equal(t, EMaxTotalSessions, err.Code())
+}
+
+func TestConcurrentSessionAccounting(t *testing.T) {
+	const maxSessions = 5
+	ssh := &sshConnectionHandler{
+		config: Config{
+			MaxSessions: maxSessions,
+		},
+		backend: &dummySSHBackend{},
+		lock:    &sync.Mutex{},
+		logger:  log.NewTestLogger(t),
+	}
+
+	lock := &sync.Mutex{}
+	open := 0
+	maxOpen := 0
+	opened := 0
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(channelID uint64) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				handler, err := ssh.OnSessionChannel(channelID, []byte{}, &sessionChannel{})
+				if err != nil {
+					continue
+				}
+				lock.Lock()
+				open++
+				opened++
+				if open > maxOpen {
+					maxOpen = open
+				}
+				lock.Unlock()
+				runtime.Gosched()
+				lock.Lock()
+				open--
+				lock.Unlock()
+				handler.OnClose()
+			}
+		}(uint64(i))
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxOpen, maxSessions)
+	assert.Equal(t, uint(0), ssh.sessionCount)
+	assert.Equal(t, uint(opened), ssh.totalSessionCount)
+}
+
+func TestGroupOverridesAtHandshake(t *testing.T) {
+	handler, err := New(
+		Config{
+			MaxSessions: -1,
+			Shell:       ShellConfig{Mode: ExecutionPolicyDisable},
+			Groups: []GroupOverrideConfig{
+				{
+					Group: "operators",
+					OverrideConfig: OverrideConfig{
+						Shell: &ShellConfig{Mode: ExecutionPolicyEnable},
+					},
+				},
+			},
+		},
+		&dummyNetworkBackend{},
+		log.NewTestLogger(t),
+		WithGroupResolver(GroupResolverFunc(func(username string) ([]string, error) {
+			switch username {
+			case "operator":
+				return []string{"operators"}, nil
+			case "broken":
+				return nil, fmt.Errorf("directory unavailable")
+			default:
+				return nil, nil
+			}
+		})),
+	)
+	assert.NoError(t, err)
+
+	connection, err := handler.OnHandshakeSuccess("operator")
+	assert.NoError(t, err)
+	session, err := connection.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.NoError(t, err)
+	assert.NoError(t, session.OnShell(0))
+
+	connection, err = handler.OnHandshakeSuccess("user")
+	assert.NoError(t, err)
+	session, err = connection.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.NoError(t, err)
+	assert.Error(t, session.OnShell(0))
+
+	_, err = handler.OnHandshakeSuccess("broken")
+	assert.Error(t, err)
+}
+
 type sessionChannel struct {
 }
 
@@ -70,19 +207,21 @@ func (s *sessionChannel) Close() error {
 }
 
 type dummySSHBackend struct {
-	exitChannel chan struct{}
+	exitChannel         chan struct{}
+	globalRequests      []string
+	unsupportedChannels []string
 }
 
 func (d *dummySSHBackend) OnShutdown(_ context.Context) {
 	panic("implement me")
 }
 
-func (d *dummySSHBackend) OnUnsupportedGlobalRequest(_ uint64, _ string, _ []byte) {
-	panic("implement me")
+func (d *dummySSHBackend) OnUnsupportedGlobalRequest(_ uint64, requestType string, _ []byte) {
+	d.globalRequests = append(d.globalRequests, requestType)
 }
 
-func (d *dummySSHBackend) OnUnsupportedChannel(_ uint64, _ string, _ []byte) {
-	panic("implement me")
+func (d *dummySSHBackend) OnUnsupportedChannel(_ uint64, channelType string, _ []byte) {
+	d.unsupportedChannels = append(d.unsupportedChannels, channelType)
 }
 
 func (d *dummySSHBackend) OnSessionChannel(
@@ -94,3 +233,14 @@ func (d *dummySSHBackend) OnSessionChannel(
 		exit: d.exitChannel,
 	}, nil
 }
+
+type dummyNetworkBackend struct {
+	sshserver.AbstractNetworkConnectionHandler
+}
+
+func (d *dummyNetworkBackend) OnHandshakeSuccess(_ string) (
+	connection sshserver.SSHConnectionHandler,
+	failureReason error,
+) {
+	return &dummySSHBackend{}, nil
+}
@@ -2,6 +2,8 @@ package security
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/containerssh/log"
 	"github.com/containerssh/sshserver"
@@ -12,9 +14,224 @@ type sessionHandler struct {
 	backend       sshserver.SessionChannelHandler
 	sshConnection *sshConnectionHandler
 	logger        log.Logger
+	channelID     uint64
+	channel       sshserver.SessionChannel
+	startTime     time.Time
+	closeOnce     sync.Once
+	// configLock guards config when the configuration is replaced on a live session.
+	configLock sync.RWMutex
+	// stateLock guards the fields below.
+	stateLock sync.Mutex
+	// accepted is set when the first request arrives, after the channel has been accepted by the SSH server.
+	accepted bool
+	// terminated is set when the session is closed by the security layer.
+	terminated bool
+	// channelClosed is set when the security layer has closed the channel.
+	channelClosed bool
+	// requestType and program record the program request of the session for re-evaluation.
+	requestType string
+	program     string
+	// forbidTTY is set if the running program must not have a TTY.
+	forbidTTY bool
+	// envCount and envBytes count the environment variables set in the session for the MaxVariables and MaxTotalBytes
+	// limits.
+	envCount int
+	envBytes int
+	// timeout enforces MaxSessionDuration and IdleTimeout. May be nil.
+	timeout *sessionTimeout
+	// quota enforces the data transfer quotas. May be nil.
+	quota *sessionQuota
+	// window coalesces window changes according to TTYConfig.ResizeInterval. May be nil.
+	window *windowCoalescer
+	// ptyGranted is set when the backend has accepted a TTY request. Guarded by stateLock.
+	ptyGranted bool
+	// rateLimiter keeps the session rate limits.
+	rateLimiter *rateLimiter
+}
+
+// getConfig returns the current configuration of the session.
+func (s *sessionHandler) getConfig() Config {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.config
+}
+
+// setConfig replaces the configuration of the session for subsequent requests.
+func (s *sessionHandler) setConfig(config Config) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	s.config = config
+}
+
+// beginRequest marks the session as accepted. If the session was terminated before the first request arrived it closes
+// the channel and returns an error. Otherwise it checks the rate limits and the sequencing rules for the request type.
+func (s *sessionHandler) beginRequest(requestType string) error {
+	s.stateLock.Lock()
+	s.accepted = true
+	terminated := s.terminated
+	s.stateLock.Unlock()
+	if !terminated {
+		if err := s.checkRateLimit(requestType); err != nil {
+			return err
+		}
+		return s.checkSequence(s.getConfig(), requestType)
+	}
+	s.closeChannel()
+	return log.UserMessage(
+		ESessionTerminated,
+		"Your session has been closed.",
+		"Rejecting request because the session has been closed by the security layer.",
+	)
+}
+
+// hasPTY returns true if the backend has accepted a TTY request for the session.
+func (s *sessionHandler) hasPTY() bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.ptyGranted
+}
+
+// setProgram records the program request of the session once the backend has started the program. From this point
+// on the program is considered started.
+func (s *sessionHandler) setProgram(requestType string, program string, forbidTTY bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.requestType = requestType
+	s.program = program
+	s.forbidTTY = forbidTTY
+}
+
+// info returns the description of the session for the session registry.
+func (s *sessionHandler) info() SessionInfo {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return SessionInfo{
+		ConnectionID:  s.sshConnection.connectionID,
+		ChannelID:     s.channelID,
+		Username:      s.sshConnection.username,
+		RemoteAddress: ipString(s.sshConnection.remoteAddress),
+		StartTime:     s.startTime,
+		RequestType:   s.requestType,
+		Program:       s.program,
+	}
+}
+
+// writeStderr writes a message to the standard error of the session if the channel is open.
+func (s *sessionHandler) writeStderr(message string) {
+	s.stateLock.Lock()
+	open := s.accepted && !s.terminated
+	s.stateLock.Unlock()
+	if !open {
+		return
+	}
+	_, _ = s.channel.Stderr().Write([]byte(message + "\r\n"))
+}
+
+// terminate closes the session. The backend is asked to shut down first using the shutdown context, then the exit
+// status is sent if not nil and the channel is closed, which results in OnClose being called by the SSH server. It
+// returns false if the session was already terminated.
+//
+// The SSH server accepts the channel after OnSessionChannel returns without notifying the handler, and the channel
+// must not be closed before that. The first request of the session is the signal that the channel is open. If the
+// session is terminated before its first request arrived, the channel is closed and the request is rejected when the
+// first request arrives.
+func (s *sessionHandler) terminate(shutdownContext context.Context, reason log.Message, exitStatus *uint32) bool {
+	s.stateLock.Lock()
+	if s.terminated {
+		s.stateLock.Unlock()
+		return false
+	}
+	s.terminated = true
+	accepted := s.accepted
+	s.stateLock.Unlock()
+
+	s.sshConnection.addLabels(reason)
+	reason.Label("channelId", s.channelID)
+	s.logger.Info(reason)
+	s.backend.OnShutdown(shutdownContext)
+	if !accepted {
+		return true
+	}
+	if exitStatus != nil {
+		s.channel.ExitStatus(*exitStatus)
+	}
+	s.closeChannel()
+	return true
+}
+
+// closeChannel closes the channel unless it has already been closed by the security layer or by the client. It must
+// only be called once the channel has been accepted.
+func (s *sessionHandler) closeChannel() {
+	s.stateLock.Lock()
+	if s.channelClosed {
+		s.stateLock.Unlock()
+		return
+	}
+	s.channelClosed = true
+	s.stateLock.Unlock()
+	_ = s.channel.Close()
+}
+
+// terminateIfViolating re-evaluates the program request of the session against its current configuration and
+// terminates the session if the request would be rejected. It returns true if the session was terminated.
+func (s *sessionHandler) terminateIfViolating(shutdownContext context.Context) bool {
+	s.stateLock.Lock()
+	requestType := s.requestType
+	program := s.program
+	s.stateLock.Unlock()
+
+	config := s.getConfig()
+	var decision Decision
+	var rule *CommandRule
+	var rejection log.Message
+	var enforcement EnforcementMode
+	switch requestType {
+	case "exec":
+		decision, rule, rejection = config.checkExec(program)
+		enforcement = config.Command.Enforcement
+	case "shell":
+		decision, rejection = config.checkShell()
+		enforcement = config.Shell.Enforcement
+	case "subsystem":
+		decision, rejection = config.checkSubsystem(program)
+		enforcement = config.Subsystem.Enforcement
+	default:
+		return false
+	}
+	if rejection == nil {
+		decision, rejection = config.checkTTYRequirement(decision, requestType, rule, s.hasPTY())
+	}
+	if rejection == nil {
+		return false
+	}
+	if err := s.decide(config, enforcement, decision, rejection); err == nil {
+		return false
+	}
+	return s.terminate(shutdownContext, log.WrapUser(
+		rejection,
+		ESessionTerminated,
+		"Your session has been closed because it is no longer allowed by the security policy.",
+		"Closing the session because its %s request is rejected by the new security configuration.",
+		requestType,
+	), nil)
 }
 
 func (s *sessionHandler) OnClose() {
+	s.stateLock.Lock()
+	s.channelClosed = true
+	s.stateLock.Unlock()
+	s.closeOnce.Do(func() {
+		if s.timeout != nil {
+			s.timeout.stop()
+		}
+		if s.quota != nil {
+			s.quota.stop()
+		}
+		if s.window != nil {
+			s.window.stop()
+		}
+		s.sshConnection.releaseSession(s)
+	})
 	s.backend.OnClose()
 }
 
@@ -22,7 +239,30 @@ func (s *sessionHandler) OnShutdown(shutdownContext context.Context) {
 	s.backend.OnShutdown(shutdownContext)
 }
 
+// OnUnsupportedChannelRequest evaluates the policy for channel requests the sshserver library does not decode. The
+// sshserver library replies to these requests on its own, the security layer decides whether the backend gets to see
+// them.
 func (s *sessionHandler) OnUnsupportedChannelRequest(requestID uint64, requestType string, payload []byte) {
+	if err := s.beginRequest(requestType); err != nil {
+		return
+	}
+	config := s.getConfig()
+	var decision Decision
+	var rejection log.Message
+	enforcement := EnforcementModeUnconfigured
+	switch requestType {
+	case "x11-req":
+		decision, rejection = config.checkX11(payload)
+		enforcement = config.X11.Enforcement
+	case "auth-agent-req@openssh.com":
+		decision, rejection = config.checkAgentForwarding()
+		enforcement = config.AgentForwarding.Enforcement
+	default:
+		decision, rejection = config.checkChannelRequest(requestType)
+	}
+	if err := s.decide(config, enforcement, decision, rejection); err != nil {
+		return
+	}
 	s.backend.OnUnsupportedChannelRequest(requestID, requestType, payload)
 }
 
@@ -32,64 +272,64 @@ func (s *sessionHandler) OnFailedDecodeChannelRequest(
 	payload []byte,
 	reason error,
 ) {
+	if err := s.beginRequest(requestType); err != nil {
+		return
+	}
 	s.backend.OnFailedDecodeChannelRequest(requestID, requestType, payload, reason)
 }
 
-func (s *sessionHandler) getPolicy(primary ExecutionPolicy) ExecutionPolicy {
-	if primary != ExecutionPolicyUnconfigured {
-		return primary
+// decide labels the rejection with the session details, logs it and records the decision. It returns the rejection as
+// an error if it must be enforced, or nil if the request may proceed because there is no rejection or the enforcement
+// mode is audit.
+func (s *sessionHandler) decide(
+	config Config,
+	enforcement EnforcementMode,
+	decision Decision,
+	rejection log.Message,
+) error {
+	channelID := s.channelID
+	decision.ChannelID = &channelID
+	if rejection != nil {
+		rejection.Label("channelId", s.channelID)
 	}
-	if s.config.DefaultMode != ExecutionPolicyUnconfigured {
-		return s.config.DefaultMode
+	decision = s.sshConnection.addDecisionDetails(decision, rejection)
+	if !s.sshConnection.options.decide(s.logger, config.getEnforcement(enforcement), decision, rejection) {
+		return nil
 	}
-	return ExecutionPolicyEnable
+	return rejection
 }
 
-func (s *sessionHandler) contains(items []string, item string) bool {
-	for _, searchItem := range items {
-		if searchItem == item {
-			return true
-		}
+// rewrite marks an allowed decision as rewritten to the forced command if one applies.
+func (s *sessionHandler) rewrite(decision Decision, rejection log.Message, forceCommand string) Decision {
+	if rejection == nil && forceCommand != "" {
+		decision.Outcome = DecisionOutcomeRewrite
+		decision.Rewrite = forceCommand
 	}
-	return false
+	return decision
 }
 
 func (s *sessionHandler) OnEnvRequest(requestID uint64, name string, value string) error {
-	mode := s.getPolicy(s.config.Env.Mode)
-	switch mode {
-	case ExecutionPolicyDisable:
-		err := log.UserMessage(
-			EEnvRejected,
-			"Environment variable setting rejected.",
-			"Setting an environment variable is rejected because it is disabled in the security settings.",
-		).Label("name", name)
-		s.logger.Debug(err)
+	if err := s.beginRequest("env"); err != nil {
 		return err
-	case ExecutionPolicyFilter:
-		if s.contains(s.config.Env.Allow, name) {
-			return s.backend.OnEnvRequest(requestID, name, value)
-		}
-		err := log.UserMessage(
-			EEnvRejected,
-			"Environment variable setting rejected.",
-			"Setting an environment variable is rejected because it does not match the allow list.",
-		).Label("name", name)
-		s.logger.Debug(err)
+	}
+	config := s.getConfig()
+	decision, rejection := config.checkEnv(name)
+	if rejection == nil {
+		decision, rejection = config.checkEnvValue(decision, name, value)
+	}
+	if rejection == nil {
+		decision, rejection = s.checkEnvLimits(config, decision, name, value)
+	}
+	if err := s.decide(config, config.Env.Enforcement, decision, rejection); err != nil {
 		return err
-	case ExecutionPolicyEnable:
-		fallthrough
-	default:
-		if !s.contains(s.config.Env.Deny, name) {
-			return s.backend.OnEnvRequest(requestID, name, value)
-		}
-		err := log.UserMessage(
-			EEnvRejected,
-			"Environment variable setting rejected.",
-			"Setting an environment variable is rejected because it matches the deny list.",
-		).Label("name", name)
-		s.logger.Debug(err)
+	}
+	if err := s.backend.OnEnvRequest(requestID, name, value); err != nil {
 		return err
 	}
+	if rejection == nil {
+		s.countEnv(name, value)
+	}
+	return nil
 }
 
 func (s *sessionHandler) OnPtyRequest(
@@ -101,199 +341,184 @@ func (s *sessionHandler) OnPtyRequest(
 	height uint32,
 	modeList []byte,
 ) error {
-	mode := s.getPolicy(s.config.TTY.Mode)
-	switch mode {
-	case ExecutionPolicyDisable:
-		fallthrough
-	case ExecutionPolicyFilter:
-		err := log.UserMessage(
-			ETTYRejected,
-			"TTY allocation disabled.",
-			"TTY allocation is disabled in the security settings.",
-		)
-		s.logger.Debug(err)
+	if err := s.beginRequest("pty-req"); err != nil {
 		return err
-	case ExecutionPolicyEnable:
-		fallthrough
-	default:
-		return s.backend.OnPtyRequest(requestID, term, columns, rows, width, height, modeList)
 	}
+	config := s.getConfig()
+	decision, modeList, rejection := config.checkPty(ttyRequest{
+		term:     term,
+		columns:  columns,
+		rows:     rows,
+		width:    width,
+		height:   height,
+		modeList: modeList,
+	})
+	if rejection == nil {
+		decision, rejection = s.checkPtyForbidden(decision)
+	}
+	if err := s.decide(config, config.TTY.Enforcement, decision, rejection); err != nil {
+		return err
+	}
+	if err := s.backend.OnPtyRequest(requestID, term, columns, rows, width, height, modeList); err != nil {
+		return err
+	}
+	s.stateLock.Lock()
+	s.ptyGranted = true
+	s.stateLock.Unlock()
+	return nil
 }
 
 func (s *sessionHandler) OnExecRequest(
 	requestID uint64,
 	program string,
 ) error {
-	mode := s.getPolicy(s.config.Command.Mode)
-	switch mode {
-	case ExecutionPolicyDisable:
-		err := log.UserMessage(
-			EExecRejected,
-			"Command execution disabled.",
-			"Command execution is disabled in the security settings.",
-		)
-		s.logger.Debug(err)
+	if err := s.beginRequest("exec"); err != nil {
 		return err
-	case ExecutionPolicyFilter:
-		if !s.contains(s.config.Command.Allow, program) {
-			err := log.UserMessage(
-				EExecRejected,
-				"Command execution disabled.",
-				"The specified command passed from the client does not match the specified allow list.",
-			)
-			s.logger.Debug(err)
-			return err
-		}
-	case ExecutionPolicyEnable:
-		fallthrough
-	default:
 	}
-	if s.config.ForceCommand == "" {
-		return s.backend.OnExecRequest(requestID, program)
+	config := s.getConfig()
+	decision, rule, rejection := config.checkExec(program)
+	if rejection == nil {
+		decision, rejection = config.checkTTYRequirement(decision, "exec", rule, s.hasPTY())
 	}
-	if err := s.backend.OnEnvRequest(requestID, "SSH_ORIGINAL_COMMAND", program); err != nil {
-		err := log.WrapUser(
-			err,
-			EFailedSetEnv,
-			"Could not execute program.",
-			"Command execution failed because the security layer could not set the SSH_ORIGINAL_COMMAND variable.",
-		)
-		s.logger.Error(err)
+	decision, forceCommand, err := s.applyForceCommand(config, decision, rejection, "exec", program, rule)
+	if err != nil {
+		return err
+	}
+	if err := s.decide(config, config.Command.Enforcement, decision, rejection); err != nil {
 		return err
 	}
-	s.logger.Debug(log.NewMessage(
-		MForcingCommand,
-		"Forcing command execution to %s",
-		s.config.ForceCommand,
-	))
-	return s.backend.OnExecRequest(requestID, s.config.ForceCommand)
+	if err := s.injectEnv(requestID, config); err != nil {
+		return err
+	}
+	if forceCommand == "" {
+		err = s.backend.OnExecRequest(requestID, program)
+	} else {
+		err = s.execForceCommand(requestID, "exec", program, forceCommand)
+	}
+	if err != nil {
+		return err
+	}
+	_, forbidTTY := config.ttyRequirement("exec", rule)
+	s.setProgram("exec", program, forbidTTY)
+	return nil
 }
 
 func (s *sessionHandler) OnShell(
 	requestID uint64,
 ) error {
-	mode := s.getPolicy(s.config.Shell.Mode)
-	switch mode {
-	case ExecutionPolicyDisable:
-		fallthrough
-	case ExecutionPolicyFilter:
-		err := log.UserMessage(
-			EShellRejected,
-			"Shell execution disabled.",
-			"Shell execution is disabled in the security settings.",
-		)
-		s.logger.Debug(err)
+	if err := s.beginRequest("shell"); err != nil {
+		return err
+	}
+	config := s.getConfig()
+	decision, rejection := config.checkShell()
+	if rejection == nil {
+		decision, rejection = config.checkTTYRequirement(decision, "shell", nil, s.hasPTY())
+	}
+	decision, forceCommand, err := s.applyForceCommand(config, decision, rejection, "shell", "", nil)
+	if err != nil {
 		return err
-	case ExecutionPolicyEnable:
-		fallthrough
-	default:
 	}
-	if s.config.ForceCommand == "" {
-		return s.backend.OnShell(requestID)
+	if err := s.decide(config, config.Shell.Enforcement, decision, rejection); err != nil {
+		return err
+	}
+	if err := s.injectEnv(requestID, config); err != nil {
+		return err
+	}
+	if forceCommand == "" {
+		err = s.backend.OnShell(requestID)
+	} else {
+		err = s.execForceCommand(requestID, "shell", "", forceCommand)
 	}
-	s.logger.Debug(log.NewMessage(
-		MForcingCommand,
-		"Forcing command execution to %s",
-		s.config.ForceCommand,
-	))
-	return s.backend.OnExecRequest(requestID, s.config.ForceCommand)
+	if err != nil {
+		return err
+	}
+	_, forbidTTY := config.ttyRequirement("shell", nil)
+	s.setProgram("shell", "", forbidTTY)
+	return nil
 }
 
 func (s *sessionHandler) OnSubsystem(
 	requestID uint64,
 	subsystem string,
 ) error {
-	mode := s.getPolicy(s.config.Subsystem.Mode)
-	switch mode {
-	case ExecutionPolicyDisable:
-		err := log.UserMessage(
-			ESubsystemRejected,
-			"Subsystem execution disabled.",
-			"Subsystem execution is disabled in the security settings.",
-		)
-		s.logger.Debug(err)
+	if err := s.beginRequest("subsystem"); err != nil {
 		return err
-	case ExecutionPolicyFilter:
-		if !s.contains(s.config.Subsystem.Allow, subsystem) {
-			err := log.UserMessage(
-				ESubsystemRejected,
-				"Subsystem execution disabled.",
-				"The specified subsystem does not match the allowed subsystems list.",
-			)
-			s.logger.Debug(err)
-			return err
-		}
-	case ExecutionPolicyEnable:
-		if s.contains(s.config.Subsystem.Deny, subsystem) {
-			err := log.UserMessage(
-				ESubsystemRejected,
-				"Subsystem execution disabled.",
-				"The subsystem execution is rejected because the specified subsystem matches the deny list.",
-			)
-			s.logger.Debug(err)
-			return err
-		}
-	default:
 	}
-	if s.config.ForceCommand == "" {
-		return s.backend.OnSubsystem(requestID, subsystem)
+	config := s.getConfig()
+	decision, rejection := config.checkSubsystem(subsystem)
+	if rejection == nil {
+		decision, rejection = config.checkTTYRequirement(decision, "subsystem", nil, s.hasPTY())
+	}
+	decision, forceCommand, err := s.applyForceCommand(config, decision, rejection, "subsystem", subsystem, nil)
+	if err != nil {
+		return err
+	}
+	mappedCommand := ""
+	if forceCommand == "" {
+		mappedCommand = config.Subsystem.Map[subsystem]
+		decision = s.rewrite(decision, rejection, mappedCommand)
+	}
+	if err := s.decide(config, config.Subsystem.Enforcement, decision, rejection); err != nil {
+		return err
 	}
-	if err := s.backend.OnEnvRequest(requestID, "SSH_ORIGINAL_COMMAND", subsystem); err != nil {
-		err := log.WrapUser(
-			err,
-			EFailedSetEnv,
-			"Could not execute program.",
-			"Command execution failed because the security layer could not set the SSH_ORIGINAL_COMMAND variable.",
+	if err := s.injectEnv(requestID, config); err != nil {
+		return err
+	}
+	switch {
+	case forceCommand != "":
+		err = s.execForceCommand(requestID, "subsystem", subsystem, forceCommand)
+	case mappedCommand != "":
+		message := log.NewMessage(
+			MSubsystemMapped,
+			"Mapping subsystem %s to command %s",
+			subsystem,
+			mappedCommand,
 		)
-		s.logger.Error(err)
+		s.sshConnection.addLabels(message)
+		s.logger.Info(message.Label("channelId", s.channelID))
+		err = s.backend.OnExecRequest(requestID, mappedCommand)
+	default:
+		err = s.backend.OnSubsystem(requestID, subsystem)
+	}
+	if err != nil {
 		return err
 	}
-	s.logger.Debug(log.NewMessage(
-		MForcingCommand,
-		"Forcing command execution to %s",
-		s.config.ForceCommand,
-	))
-	return s.backend.OnExecRequest(requestID, s.config.ForceCommand)
+	_, forbidTTY := config.ttyRequirement("subsystem", nil)
+	s.setProgram("subsystem", subsystem, forbidTTY)
+	return nil
 }
 
 func (s *sessionHandler) OnSignal(requestID uint64, signal string) error {
-	mode := s.getPolicy(s.config.Shell.Mode)
-	switch mode {
-	case ExecutionPolicyDisable:
-		err := log.UserMessage(
-			ESignalRejected,
-			"Sending signals is rejected.",
-			"Sending the signal is rejected because signal delivery is disabled.",
-		)
-		s.logger.Debug(err)
-		return err
-	case ExecutionPolicyFilter:
-		if s.contains(s.config.Signal.Allow, signal) {
-			return s.backend.OnSignal(requestID, signal)
-		}
-		err := log.UserMessage(
-			ESignalRejected,
-			"Sending signals is rejected.",
-			"Sending the signal is rejected because the specified signal does not match the allow list.",
-		)
-		s.logger.Debug(err)
+	if err := s.beginRequest("signal"); err != nil {
 		return err
-	case ExecutionPolicyEnable:
-		fallthrough
-	default:
-		if !s.contains(s.config.Signal.Deny, signal) {
-			return s.backend.OnSignal(requestID, signal)
-		}
-		err := log.UserMessage(
-			ESignalRejected,
-			"Sending signals is rejected.",
-			"Sending the signal is rejected because the specified signal matches the deny list.",
-		)
-		s.logger.Debug(err)
+	}
+	config := s.getConfig()
+	decision, rejection := config.checkSignal(signal)
+	if err := s.decide(config, config.Signal.Enforcement, decision, rejection); err != nil {
 		return err
 	}
+	return s.backend.OnSignal(requestID, signal)
 }
 
 func (s *sessionHandler) OnWindow(requestID uint64, columns uint32, rows uint32, width uint32, height uint32) error {
-	return s.backend.OnWindow(requestID, columns, rows, width, height)
+	if err := s.beginRequest("window-change"); err != nil {
+		return err
+	}
+	config := s.getConfig()
+	size := windowSize{columns: columns, rows: rows, width: width, height: height}
+	decision, size, rejection := s.checkWindow(config, size)
+	if err := s.decide(config, config.TTY.Enforcement, decision, rejection); err != nil {
+		return err
+	}
+	if s.window != nil && s.window.submit(requestID, size) {
+		message := log.NewMessage(
+			MWindowChangeCoalesced,
+			"Holding back the window change to %s because of the resize interval.",
+			size,
+		)
+		s.sshConnection.addLabels(message)
+		s.logger.Debug(message.Label("channelId", s.channelID))
+		return nil
+	}
+	return s.backend.OnWindow(requestID, size.columns, size.rows, size.width, size.height)
 }
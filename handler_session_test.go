@@ -2,11 +2,14 @@ package security
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"sync"
 	"testing"
 
 	"github.com/containerssh/log"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestEnvRequest(t *testing.T) {
@@ -40,6 +43,185 @@ func TestEnvRequest(t *testing.T) {
 	assert.Error(t, session.OnEnvRequest(9, "DENY_ME", "bar"))
 }
 
+func TestEnvRequestPatterns(t *testing.T) {
+	session := &sessionHandler{
+		config: Config{
+			Env: EnvConfig{
+				Mode:  ExecutionPolicyFilter,
+				Allow: []string{"glob:LC_*", "regex:GIT_(AUTHOR|COMMITTER)_NAME"},
+			},
+		},
+		backend: &dummyBackend{},
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	assert.NoError(t, session.OnEnvRequest(1, "LC_ALL", "C"))
+	assert.NoError(t, session.OnEnvRequest(2, "GIT_AUTHOR_NAME", "foo"))
+	assert.Error(t, session.OnEnvRequest(3, "GIT_AUTHOR_EMAIL", "foo"))
+	assert.Error(t, session.OnEnvRequest(4, "LANG", "C"))
+}
+
+func TestEnvRequestValues(t *testing.T) {
+	session := &sessionHandler{
+		config: Config{
+			Env: EnvConfig{
+				Values: []EnvValueConfig{
+					{
+						Name:           "glob:*",
+						MaxLength:      16,
+						ForbidNUL:      true,
+						ForbidNewlines: true,
+					},
+					{
+						Name:  "LANG",
+						Allow: []string{"C", "regex:[a-z]{2}_[A-Z]{2}\\.UTF-8"},
+					},
+				},
+			},
+		},
+		backend: &dummyBackend{},
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	assert.NoError(t, session.OnEnvRequest(1, "LANG", "en_US.UTF-8"))
+	assert.NoError(t, session.OnEnvRequest(2, "FOO", "bar"))
+	assert.Error(t, session.OnEnvRequest(3, "LANG", "en_US"))
+	assert.Error(t, session.OnEnvRequest(4, "FOO", "this value is too long"))
+	assert.Error(t, session.OnEnvRequest(5, "FOO", "foo\x00bar"))
+	assert.Error(t, session.OnEnvRequest(6, "FOO", "foo\nbar"))
+	assert.Error(t, session.OnEnvRequest(7, "FOO", "foo\rbar"))
+}
+
+func TestEnvRequestLimits(t *testing.T) {
+	session, _ := newForceCommandTestSession(t, Config{Env: EnvConfig{MaxVariables: 2}})
+	assert.NoError(t, session.OnEnvRequest(1, "A", "1"))
+	assert.NoError(t, session.OnEnvRequest(2, "B", "2"))
+	assert.Error(t, session.OnEnvRequest(3, "C", "3"))
+
+	session, _ = newForceCommandTestSession(t, Config{Env: EnvConfig{MaxTotalBytes: 10}})
+	assert.NoError(t, session.OnEnvRequest(1, "FOO", "bar"))
+	assert.Error(t, session.OnEnvRequest(2, "BAR", "bazbaz"))
+	// Rejected variables are not counted.
+	assert.NoError(t, session.OnEnvRequest(3, "BAR", "b"))
+
+	// In audit mode variables over the limit are passed on, but not counted, so later variables are recorded with
+	// the decision an enforced policy would make.
+	session, _ = newForceCommandTestSession(t, Config{
+		Env: EnvConfig{MaxVariables: 1, MaxTotalBytes: 10, Enforcement: EnforcementModeAudit},
+	})
+	recorder := NewMemoryDecisionRecorder()
+	WithDecisionRecorder(recorder)(&session.sshConnection.options)
+	assert.NoError(t, session.OnEnvRequest(1, "A", "1"))
+	assert.NoError(t, session.OnEnvRequest(2, "B", "2"))
+	assert.NoError(t, session.OnEnvRequest(3, "C", "3"))
+	assert.Equal(t, 1, session.envCount)
+	assert.Equal(t, 2, session.envBytes)
+	decisions := recorder.Decisions()
+	assert.Len(t, decisions, 3)
+	assert.Equal(t, DecisionOutcomeAllow, decisions[0].Outcome)
+	assert.Equal(t, "maxVariables", decisions[1].MatchedRule)
+	assert.Equal(t, "maxVariables", decisions[2].MatchedRule)
+}
+
+func TestEnvValueValidation(t *testing.T) {
+	assert.Error(t, EnvConfig{Values: []EnvValueConfig{{}}}.Validate())
+	assert.Error(t, EnvConfig{Values: []EnvValueConfig{{Name: "regex:("}}}.Validate())
+	assert.Error(t, EnvConfig{Values: []EnvValueConfig{{Name: "FOO", MaxLength: -1}}}.Validate())
+	assert.Error(t, EnvConfig{MaxVariables: -1}.Validate())
+	assert.Error(t, EnvConfig{MaxTotalBytes: -1}.Validate())
+	assert.NoError(t, EnvConfig{Values: []EnvValueConfig{{Name: "glob:LC_*", MaxLength: 64}}}.Validate())
+}
+
+func TestEnvSet(t *testing.T) {
+	backend := &dummyBackend{env: map[string]string{}}
+	session := &sessionHandler{
+		config: Config{
+			Env: EnvConfig{
+				Set: map[string]string{
+					"SECURE_USER":   "{{ .Username }}",
+					"SECURE_CLIENT": "{{ .RemoteAddress }} {{ .RemotePort }} {{ .ChannelID }}",
+					"TEAM":          "platform",
+				},
+			},
+		},
+		backend:   backend,
+		channelID: 3,
+		sshConnection: &sshConnectionHandler{
+			lock:          &sync.Mutex{},
+			username:      "alice",
+			remoteAddress: net.ParseIP("192.0.2.1"),
+			remotePort:    2222,
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	assert.Error(t, session.OnEnvRequest(1, "SECURE_USER", "root"))
+	assert.NoError(t, session.OnEnvRequest(2, "LANG", "C"))
+	assert.NoError(t, session.OnShell(3))
+	assert.Equal(t, map[string]string{
+		"LANG":          "C",
+		"SECURE_USER":   "alice",
+		"SECURE_CLIENT": "192.0.2.1 2222 3",
+		"TEAM":          "platform",
+	}, backend.env)
+}
+
+func TestEnvSetTemplatesAreParsedOnce(t *testing.T) {
+	config := Config{Env: EnvConfig{Set: map[string]string{"SECURE_HOME": "/home/{{ .Username }}"}}}.parse()
+	parsed, ok := config.parsed.envTemplates["SECURE_HOME=/home/{{ .Username }}"]
+	assert.True(t, ok)
+	tpl, err := config.parsed.envTemplate("SECURE_HOME", "/home/{{ .Username }}")
+	assert.NoError(t, err)
+	assert.Same(t, parsed, tpl)
+	value, err := config.parsed.renderEnvTemplate(
+		"SECURE_HOME",
+		"/home/{{ .Username }}",
+		EnvTemplateData{Username: "alice"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/alice", value)
+}
+
+func TestEnvSetFailure(t *testing.T) {
+	session := &sessionHandler{
+		config: Config{
+			Env: EnvConfig{
+				Set: map[string]string{"FOO": "bar"},
+			},
+		},
+		backend: &envFailingBackend{},
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+	err := session.OnExecRequest(1, "ls")
+	assert.Error(t, err)
+	assert.Equal(t, EFailedSetEnv, err.(log.Message).Code())
+}
+
+func TestEnvSetValidation(t *testing.T) {
+	assert.Error(t, EnvConfig{Set: map[string]string{"": "foo"}}.Validate())
+	assert.Error(t, EnvConfig{Set: map[string]string{"A=B": "foo"}}.Validate())
+	assert.Error(t, EnvConfig{Set: map[string]string{"FOO": "{{ .Username "}}.Validate())
+	assert.NoError(t, EnvConfig{Set: map[string]string{"FOO": "{{ .Username }}"}}.Validate())
+}
+
+// envFailingBackend refuses all environment variables.
+type envFailingBackend struct {
+	dummyBackend
+}
+
+func (e *envFailingBackend) OnEnvRequest(_ uint64, _ string, _ string) error {
+	return fmt.Errorf("environment variables are not supported")
+}
+
 func TestPTYRequest(t *testing.T) {
 	session := &sessionHandler{
 		config:  Config{},
@@ -177,11 +359,125 @@ func TestSubsystem(t *testing.T) {
 	assert.Equal(t, map[string]string{"SSH_ORIGINAL_COMMAND": "sftp"}, backend.env)
 }
 
+func TestSubsystemMap(t *testing.T) {
+	config := Config{
+		Subsystem: SubsystemConfig{
+			Mode:  ExecutionPolicyFilter,
+			Allow: []string{"sftp", "backup", "netconf"},
+			Map: map[string]string{
+				"sftp":   "/usr/lib/openssh/sftp-server -e",
+				"backup": "/opt/backup.sh",
+			},
+		},
+	}
+	recorder := NewMemoryDecisionRecorder()
+
+	session, backend := newForceCommandTestSession(t, config)
+	WithDecisionRecorder(recorder)(&session.sshConnection.options)
+	assert.NoError(t, session.OnSubsystem(1, "sftp"))
+	assert.Equal(t, []string{"/usr/lib/openssh/sftp-server -e"}, backend.commandsExecuted)
+	assert.Equal(t, map[string]string{}, backend.env)
+	decisions := recorder.Decisions()
+	assert.Len(t, decisions, 1)
+	assert.Equal(t, DecisionOutcomeRewrite, decisions[0].Outcome)
+	assert.Equal(t, "/usr/lib/openssh/sftp-server -e", decisions[0].Rewrite)
+
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnSubsystem(1, "netconf"))
+	assert.Equal(t, []string{"netconf"}, backend.commandsExecuted)
+
+	session, backend = newForceCommandTestSession(t, config)
+	assert.Error(t, session.OnSubsystem(1, "other"))
+	assert.Empty(t, backend.commandsExecuted)
+
+	config.ForceCommand = "/bin/wrapper"
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnSubsystem(1, "backup"))
+	assert.Equal(t, []string{"/bin/wrapper"}, backend.commandsExecuted)
+	assert.Equal(t, "backup", backend.env["SSH_ORIGINAL_COMMAND"])
+
+	config.Subsystem.ForceCommands = map[string]string{"backup": ""}
+	session, backend = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnSubsystem(1, "backup"))
+	assert.Equal(t, []string{"/opt/backup.sh"}, backend.commandsExecuted)
+}
+
+func TestSubsystemMapValidation(t *testing.T) {
+	assert.NoError(t, Config{Subsystem: SubsystemConfig{Map: map[string]string{"sftp": "/bin/sftp"}}}.Validate())
+	assert.Error(t, Config{Subsystem: SubsystemConfig{Map: map[string]string{"": "/bin/sftp"}}}.Validate())
+	assert.Error(t, Config{Subsystem: SubsystemConfig{Map: map[string]string{"sftp": ""}}}.Validate())
+	assert.Error(t, Config{Subsystem: SubsystemConfig{Map: map[string]string{"*": "/bin/sftp"}}}.Validate())
+}
+
+func TestX11Forwarding(t *testing.T) {
+	backend := &dummyBackend{}
+	session := &sessionHandler{
+		config:  Config{},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+	singleCookie := ssh.Marshal(x11RequestPayload{true, "MIT-MAGIC-COOKIE-1", "abcd", 0})
+	multiCookie := ssh.Marshal(x11RequestPayload{false, "MIT-MAGIC-COOKIE-1", "abcd", 0})
+	singleOther := ssh.Marshal(x11RequestPayload{true, "XDM-AUTHORIZATION-1", "abcd", 0})
+
+	session.config.X11.Mode = ExecutionPolicyEnable
+	assert.NoError(t, checkX11Error(session.config, multiCookie))
+
+	session.config.X11.Mode = ExecutionPolicyFilter
+	session.config.X11.AllowAuthProtocols = []string{"MIT-MAGIC-COOKIE-1"}
+	session.config.X11.RequireSingleConnection = true
+	assert.NoError(t, checkX11Error(session.config, singleCookie))
+	assert.Error(t, checkX11Error(session.config, multiCookie))
+	assert.Error(t, checkX11Error(session.config, singleOther))
+	assert.Error(t, checkX11Error(session.config, []byte{}))
+
+	session.config.X11.Mode = ExecutionPolicyDisable
+	assert.Error(t, checkX11Error(session.config, singleCookie))
+
+	session.config.X11.Mode = ExecutionPolicyFilter
+	session.OnUnsupportedChannelRequest(1, "x11-req", singleCookie)
+	session.OnUnsupportedChannelRequest(2, "x11-req", multiCookie)
+	assert.Equal(t, []string{"x11-req"}, backend.unsupportedRequests)
+}
+
+func TestAgentForwarding(t *testing.T) {
+	backend := &dummyBackend{}
+	session := &sessionHandler{
+		config:  Config{},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	session.config.AgentForwarding.Mode = ExecutionPolicyEnable
+	session.OnUnsupportedChannelRequest(1, "auth-agent-req@openssh.com", []byte{})
+	session.config.AgentForwarding.Mode = ExecutionPolicyFilter
+	session.OnUnsupportedChannelRequest(2, "auth-agent-req@openssh.com", []byte{})
+	session.config.AgentForwarding.Mode = ExecutionPolicyDisable
+	session.OnUnsupportedChannelRequest(3, "auth-agent-req@openssh.com", []byte{})
+	assert.Equal(t, []string{"auth-agent-req@openssh.com"}, backend.unsupportedRequests)
+}
+
+func checkX11Error(config Config, payload []byte) error {
+	if _, rejection := config.checkX11(payload); rejection != nil {
+		return rejection
+	}
+	return nil
+}
+
 // region Dummy backend
 type dummyBackend struct {
-	exit             chan struct{}
-	env              map[string]string
-	commandsExecuted []string
+	exit                chan struct{}
+	env                 map[string]string
+	commandsExecuted    []string
+	unsupportedRequests []string
+	// execErr is returned by OnExecRequest if set.
+	execErr error
 }
 
 func (d *dummyBackend) OnClose() {
@@ -190,8 +486,8 @@ func (d *dummyBackend) OnClose() {
 func (d *dummyBackend) OnShutdown(_ context.Context) {
 }
 
-func (d *dummyBackend) OnUnsupportedChannelRequest(_ uint64, _ string, _ []byte) {
-
+func (d *dummyBackend) OnUnsupportedChannelRequest(_ uint64, requestType string, _ []byte) {
+	d.unsupportedRequests = append(d.unsupportedRequests, requestType)
 }
 
 func (d *dummyBackend) OnFailedDecodeChannelRequest(
@@ -226,6 +522,9 @@ func (d *dummyBackend) OnExecRequest(
 	_ uint64,
 	program string,
 ) error {
+	if d.execErr != nil {
+		return d.execErr
+	}
 	d.commandsExecuted = append(d.commandsExecuted, program)
 	return nil
 }
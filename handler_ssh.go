@@ -2,6 +2,8 @@ package security
 
 import (
 	"context"
+	"net"
+	"strconv"
 	"sync"
 
 	"github.com/containerssh/log"
@@ -13,8 +15,136 @@ type sshConnectionHandler struct {
 	config       Config
 	backend      sshserver.SSHConnectionHandler
 	sessionCount uint
-	lock         *sync.Mutex
-	logger       log.Logger
+	// totalSessionCount counts all sessions ever opened on this connection for the MaxTotalSessions limit.
+	totalSessionCount uint
+	lock              *sync.Mutex
+	logger            log.Logger
+	username          string
+	connectionID      string
+	remoteAddress     net.IP
+	remotePort        int
+	// limiter is the limiter shared across connections. May be nil.
+	limiter *Limiter
+	options options
+	// groups contains the groups of the user if groupsResolved is true.
+	groups         []string
+	groupsResolved bool
+	// sessions contains the open sessions of the connection. Guarded by lock.
+	sessions map[*sessionHandler]struct{}
+	// configLock guards config when the configuration is replaced on a live connection.
+	configLock sync.RWMutex
+	// transfer counts the data transferred for the connection quotas. Created with the first session with a quota.
+	transfer *connectionTransfer
+	// rateLimiter keeps the connection rate limits. Created on first use, guarded by lock.
+	rateLimiter *rateLimiter
+	// disconnected is set when the connection is closed by the security layer. Guarded by lock.
+	disconnected bool
+}
+
+// getRateLimiter returns the rate limiter shared by the sessions of the connection.
+func (s *sshConnectionHandler) getRateLimiter() *rateLimiter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.rateLimiter == nil {
+		s.rateLimiter = newRateLimiter(s.options.getClock())
+	}
+	return s.rateLimiter
+}
+
+// disconnect closes all sessions of the connection and rejects all further channels and requests on it. The reason
+// function is called for each session to create the message it is closed with.
+func (s *sshConnectionHandler) disconnect(shutdownContext context.Context, reason func() log.Message) {
+	s.lock.Lock()
+	s.disconnected = true
+	s.lock.Unlock()
+	sessions := s.listSessions()
+	wg := &sync.WaitGroup{}
+	wg.Add(len(sessions))
+	for _, session := range sessions {
+		go func(session *sessionHandler) {
+			defer wg.Done()
+			session.terminate(shutdownContext, reason(), nil)
+		}(session)
+	}
+	wg.Wait()
+}
+
+// checkDisconnected returns a rejection if the connection has been closed by the security layer.
+func (s *sshConnectionHandler) checkDisconnected() log.Message {
+	s.lock.Lock()
+	disconnected := s.disconnected
+	s.lock.Unlock()
+	if !disconnected {
+		return nil
+	}
+	return newErrDisconnected()
+}
+
+func newErrDisconnected() log.Message {
+	return log.UserMessage(
+		EConnectionDisconnected,
+		"The connection has been closed.",
+		"Rejecting the request because the connection has been closed by the security layer.",
+	)
+}
+
+// getConfig returns the current configuration of the connection.
+func (s *sshConnectionHandler) getConfig() Config {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.config
+}
+
+// reevaluate applies the user, group and source overrides to the new base configuration and replaces the
+// configuration of the connection and its open sessions. If the groups of the user cannot be resolved the connection
+// keeps its current configuration.
+func (s *sshConnectionHandler) reevaluate(base Config) {
+	s.lock.Lock()
+	groups := s.groups
+	groupsResolved := s.groupsResolved
+	s.lock.Unlock()
+	if !groupsResolved {
+		var err error
+		var resolved bool
+		groups, resolved, err = s.options.resolveGroups(base, s.username)
+		if err != nil {
+			err := log.Wrap(
+				err,
+				EGroupResolutionFailed,
+				"Failed to resolve the groups of user %s, keeping the previous configuration.",
+				s.username,
+			)
+			s.addLabels(err)
+			s.logger.Warning(err)
+			return
+		}
+		s.lock.Lock()
+		s.groups = groups
+		s.groupsResolved = resolved
+		s.lock.Unlock()
+	}
+	config, _ := base.resolve(s.username, groups, s.remoteAddress)
+
+	s.configLock.Lock()
+	s.config = config
+	s.configLock.Unlock()
+
+	for _, session := range s.listSessions() {
+		session.setConfig(config)
+	}
+}
+
+// addLabels adds the connection details known to the security layer to a message.
+func (s *sshConnectionHandler) addLabels(message log.Message) {
+	if s.username != "" {
+		message.Label("username", s.username)
+	}
+	if s.connectionID != "" {
+		message.Label("connectionId", s.connectionID)
+	}
+	if s.remoteAddress != nil {
+		message.Label("remoteAddr", s.remoteAddress.String())
+	}
 }
 
 func (s *sshConnectionHandler) OnShutdown(shutdownContext context.Context) {
@@ -22,13 +152,175 @@ func (s *sshConnectionHandler) OnShutdown(shutdownContext context.Context) {
 }
 
 func (s *sshConnectionHandler) OnUnsupportedGlobalRequest(requestID uint64, requestType string, payload []byte) {
+	var decision Decision
+	var rejection log.Message
+	switch requestType {
+	case "tcpip-forward":
+		fallthrough
+	case "cancel-tcpip-forward":
+		decision, rejection = s.checkRemoteForwarding(requestType, payload)
+	default:
+		decision, rejection = s.checkGlobalRequest(requestType)
+	}
+	if disconnected := s.checkDisconnected(); disconnected != nil {
+		rejection = disconnected
+	}
+	if s.decide(EnforcementModeUnconfigured, decision, rejection) {
+		return
+	}
 	s.backend.OnUnsupportedGlobalRequest(requestID, requestType, payload)
 }
 
+// OnUnsupportedChannel evaluates the policy for channel types the sshserver library does not handle. The sshserver
+// library rejects these channels on its own, the security layer decides whether the backend gets to see them.
 func (s *sshConnectionHandler) OnUnsupportedChannel(channelID uint64, channelType string, extraData []byte) {
+	var decision Decision
+	var rejection sshserver.ChannelRejection
+	if channelType == "direct-tcpip" {
+		decision, rejection = s.checkLocalForwarding(extraData)
+	} else {
+		decision, rejection = s.checkChannelType(channelType)
+	}
+	if disconnected := s.checkDisconnected(); disconnected != nil {
+		rejection = newChannelRejection(ssh.Prohibited, disconnected)
+	}
+	decision.ChannelID = &channelID
+	if rejection != nil {
+		rejection.Label("channelId", channelID)
+	}
+	if s.decide(EnforcementModeUnconfigured, decision, rejection) {
+		return
+	}
 	s.backend.OnUnsupportedChannel(channelID, channelType, extraData)
 }
 
+// decide adds the connection details to the decision and the rejection, logs the rejection according to the
+// enforcement mode and records the decision. It returns true if the rejection must be enforced. A nil rejection is
+// never enforced.
+func (s *sshConnectionHandler) decide(enforcement EnforcementMode, decision Decision, rejection log.Message) bool {
+	decision = s.addDecisionDetails(decision, rejection)
+	return s.options.decide(s.logger, s.getConfig().getEnforcement(enforcement), decision, rejection)
+}
+
+// addDecisionDetails adds the connection details known to the security layer to the decision and the rejection.
+func (s *sshConnectionHandler) addDecisionDetails(decision Decision, rejection log.Message) Decision {
+	decision.Username = s.username
+	decision.ConnectionID = s.connectionID
+	decision.RemoteAddress = ipString(s.remoteAddress)
+	if rejection != nil {
+		s.addLabels(rejection)
+	}
+	return decision
+}
+
+// checkGlobalRequest evaluates a global request without a dedicated section against the policy for unsupported
+// global requests.
+func (s *sshConnectionHandler) checkGlobalRequest(requestType string) (Decision, log.Message) {
+	config := s.getConfig()
+	mode := config.getPolicy(config.Unsupported.GlobalRequests.Mode)
+	decision := Decision{RequestType: requestType, Mode: mode}
+	rule, reason := config.Unsupported.GlobalRequests.check(config.parsed, mode, requestType)
+	decision.MatchedRule = rule
+	if reason == "" {
+		return decision, nil
+	}
+	return decision, log.UserMessage(
+		EGlobalRequestRejected,
+		"Request rejected.",
+		"The global request is rejected because %s",
+		reason,
+	).Label("requestType", requestType)
+}
+
+// checkChannelType evaluates a channel type without a dedicated section against the policy for unsupported channel
+// types.
+func (s *sshConnectionHandler) checkChannelType(channelType string) (Decision, sshserver.ChannelRejection) {
+	config := s.getConfig()
+	mode := config.getPolicy(config.Unsupported.ChannelTypes.Mode)
+	decision := Decision{RequestType: channelType, Mode: mode}
+	rule, reason := config.Unsupported.ChannelTypes.check(config.parsed, mode, channelType)
+	decision.MatchedRule = rule
+	if reason == "" {
+		return decision, nil
+	}
+	rejection := newChannelRejection(ssh.Prohibited, log.UserMessage(
+		EChannelTypeRejected,
+		"Channel type rejected.",
+		"The channel is rejected because %s",
+		reason,
+	))
+	rejection.Label("type", channelType)
+	return decision, rejection
+}
+
+// checkLocalForwarding evaluates a direct-tcpip channel request against the local forwarding policy.
+func (s *sshConnectionHandler) checkLocalForwarding(extraData []byte) (Decision, sshserver.ChannelRejection) {
+	config := s.getConfig()
+	mode := config.getPolicy(config.Forwarding.Local.Mode)
+	decision := Decision{RequestType: "direct-tcpip", Mode: mode}
+	payload := directTCPIPPayload{}
+	if err := ssh.Unmarshal(extraData, &payload); err != nil {
+		return decision, newChannelRejection(ssh.ConnectionFailed, log.WrapUser(
+			err,
+			ELocalForwardingRejected,
+			"Port forwarding rejected.",
+			"Failed to decode the local port forwarding request.",
+		))
+	}
+	decision.Subject = net.JoinHostPort(payload.HostToConnect, strconv.FormatUint(uint64(payload.PortToConnect), 10))
+	rule, reason := config.Forwarding.Local.check(config.parsed, mode, payload.HostToConnect, payload.PortToConnect)
+	decision.MatchedRule = rule
+	if reason == "" {
+		return decision, nil
+	}
+	rejection := newChannelRejection(ssh.Prohibited, log.UserMessage(
+		ELocalForwardingRejected,
+		"Port forwarding rejected.",
+		"%s",
+		reason,
+	))
+	rejection.Label("host", payload.HostToConnect)
+	rejection.Label("port", payload.PortToConnect)
+	return decision, rejection
+}
+
+// checkRemoteForwarding evaluates a tcpip-forward or cancel-tcpip-forward global request against the remote
+// forwarding policy.
+func (s *sshConnectionHandler) checkRemoteForwarding(
+	requestType string,
+	payload []byte,
+) (Decision, sshserver.ChannelRejection) {
+	config := s.getConfig()
+	mode := config.getPolicy(config.Forwarding.Remote.Mode)
+	decision := Decision{RequestType: requestType, Mode: mode}
+	request := tcpipForwardPayload{}
+	if err := ssh.Unmarshal(payload, &request); err != nil {
+		return decision, newChannelRejection(ssh.ConnectionFailed, log.WrapUser(
+			err,
+			ERemoteForwardingRejected,
+			"Port forwarding rejected.",
+			"Failed to decode the %s request.",
+			requestType,
+		))
+	}
+	decision.Subject = net.JoinHostPort(request.AddressToBind, strconv.FormatUint(uint64(request.PortToBind), 10))
+	rule, reason := config.Forwarding.Remote.check(config.parsed, mode, request.AddressToBind, request.PortToBind)
+	decision.MatchedRule = rule
+	if reason == "" {
+		return decision, nil
+	}
+	rejection := newChannelRejection(ssh.Prohibited, log.UserMessage(
+		ERemoteForwardingRejected,
+		"Port forwarding rejected.",
+		"%s",
+		reason,
+	))
+	rejection.Label("requestType", requestType)
+	rejection.Label("host", request.AddressToBind)
+	rejection.Label("port", request.PortToBind)
+	return decision, rejection
+}
+
 func (s *sshConnectionHandler) OnSessionChannel(
 	channelID uint64,
 	extraData []byte,
@@ -36,39 +328,167 @@ func (s *sshConnectionHandler) OnSessionChannel(
 ) (channel sshserver.SessionChannelHandler, failureReason sshserver.ChannelRejection) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if s.config.MaxSessions > -1 && s.sessionCount >= uint(s.config.MaxSessions) {
-		err := &ErrTooManySessions{
-			labels: log.Labels(map[log.LabelName]log.LabelValue{}),
+	decision := Decision{RequestType: "session", ChannelID: &channelID}
+	if s.disconnected {
+		rejection := newChannelRejection(ssh.Prohibited, newErrDisconnected())
+		rejection.Label("channelId", channelID)
+		s.decide(EnforcementModeUnconfigured, decision, rejection)
+		return nil, rejection
+	}
+	if rejection := s.checkSessionLimits(); rejection != nil {
+		rejection.Label("channelId", channelID)
+		if s.decide(EnforcementModeUnconfigured, decision, rejection) {
+			return nil, rejection
 		}
-		s.logger.Debug(err)
-		return nil, err
+		if s.limiter != nil {
+			// In audit mode the session still takes a slot so the release on close stays balanced.
+			s.limiter.acquireSession(LimitsConfig{}, s.username, s.remoteAddressString())
+		}
+	} else {
+		s.decide(EnforcementModeUnconfigured, decision, nil)
+	}
+	config := s.getConfig()
+	handler := &sessionHandler{
+		config:        config,
+		sshConnection: s,
+		logger:        s.logger,
+		channelID:     channelID,
+		channel:       session,
+		startTime:     s.options.getClock().Now(),
+		rateLimiter:   newRateLimiter(s.options.getClock()),
+	}
+	if config.TTY.ResizeInterval > 0 {
+		handler.window = newWindowCoalescer(s.options.getClock(), config.TTY.ResizeInterval, handler.sendWindow)
 	}
-	backend, err := s.backend.OnSessionChannel(channelID, extraData, session)
+	backendSession := session
+	if config.Quota.enabled() {
+		if s.transfer == nil {
+			s.transfer = newConnectionTransfer()
+		}
+		handler.quota = newSessionQuota(config.Quota, s.options.getClock(), handler, s.transfer)
+		backendSession = &quotaSessionChannel{
+			SessionChannel: backendSession,
+			quota:          handler.quota,
+		}
+	}
+	if config.MaxSessionDuration > 0 || config.IdleTimeout > 0 {
+		handler.timeout = newSessionTimeout(config, s.options.getClock(), handler)
+		backendSession = &timeoutSessionChannel{
+			SessionChannel: backendSession,
+			timeout:        handler.timeout,
+		}
+	}
+	backend, err := s.backend.OnSessionChannel(channelID, extraData, backendSession)
 	if err != nil {
+		if s.limiter != nil {
+			s.limiter.releaseSession(s.username, s.remoteAddressString())
+		}
 		return nil, err
 	}
+	handler.backend = backend
 	s.sessionCount++
-	return &sessionHandler{
-		config:        s.config,
-		backend:       backend,
-		sshConnection: s,
-		logger:        s.logger,
-	}, nil
+	s.totalSessionCount++
+	if s.options.metrics != nil {
+		s.options.metrics.SessionOpened(s.connectionID)
+	}
+	if handler.timeout != nil {
+		handler.timeout.startTimer()
+	}
+	if s.sessions == nil {
+		s.sessions = map[*sessionHandler]struct{}{}
+	}
+	s.sessions[handler] = struct{}{}
+	return handler, nil
+}
+
+// checkSessionLimits checks the per-connection and shared session limits. If the session is allowed it takes a slot in
+// the limiter, if present.
+func (s *sshConnectionHandler) checkSessionLimits() *ErrTooManySessions {
+	config := s.getConfig()
+	if config.MaxSessions > -1 && s.sessionCount >= uint(config.MaxSessions) {
+		return newErrTooManySessions(EMaxSessions, "The user has opened too many sessions.")
+	}
+	if config.MaxTotalSessions > 0 && s.totalSessionCount >= uint(config.MaxTotalSessions) {
+		return newErrTooManySessions(
+			EMaxTotalSessions,
+			"The user has reached the maximum number of sessions allowed over the lifetime of the connection.",
+		)
+	}
+	if s.limiter == nil {
+		return nil
+	}
+	switch s.limiter.acquireSession(config.Limits, s.username, s.remoteAddressString()) {
+	case EMaxUserSessions:
+		return newErrTooManySessions(EMaxUserSessions, "The user has too many sessions open across all connections.")
+	case EMaxIPSessions:
+		return newErrTooManySessions(
+			EMaxIPSessions,
+			"The client IP address has too many sessions open across all connections.",
+		)
+	}
+	return nil
+}
+
+// listSessions returns the open sessions of the connection.
+func (s *sshConnectionHandler) listSessions() []*sessionHandler {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sessions := make([]*sessionHandler, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// releaseSession frees up the slot of a closed session for the MaxSessions limit.
+func (s *sshConnectionHandler) releaseSession(session *sessionHandler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, session)
+	if s.sessionCount > 0 {
+		s.sessionCount--
+	}
+	if s.options.metrics != nil {
+		s.options.metrics.SessionClosed(s.connectionID)
+	}
+	if s.limiter != nil {
+		s.limiter.releaseSession(s.username, s.remoteAddressString())
+	}
+}
+
+func (s *sshConnectionHandler) remoteAddressString() string {
+	return ipString(s.remoteAddress)
+}
+
+func newErrTooManySessions(code string, explanation string) *ErrTooManySessions {
+	return &ErrTooManySessions{
+		labels:      log.Labels(map[log.LabelName]log.LabelValue{}),
+		code:        code,
+		explanation: explanation,
+	}
 }
 
 // ErrTooManySessions indicates that too many sessions were opened in the same connection.
 type ErrTooManySessions struct {
-	labels log.Labels
+	labels      log.Labels
+	code        string
+	explanation string
 }
 
 // Label adds a label to the message.
 func (e *ErrTooManySessions) Label(name log.LabelName, value log.LabelValue) log.Message {
+	if e.labels == nil {
+		e.labels = log.Labels(map[log.LabelName]log.LabelValue{})
+	}
 	e.labels[name] = value
 	return e
 }
 
 // Code returns the error code.
 func (e *ErrTooManySessions) Code() string {
+	if e.code != "" {
+		return e.code
+	}
 	return EMaxSessions
 }
 
@@ -84,6 +504,9 @@ func (e *ErrTooManySessions) Error() string {
 
 // Explanation is the message intended for the administrator.
 func (e *ErrTooManySessions) Explanation() string {
+	if e.explanation != "" {
+		return e.explanation
+	}
 	return "The user has opened too many sessions."
 }
 
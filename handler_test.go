@@ -0,0 +1,109 @@
+package security
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/sshserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkPolicy(t *testing.T) {
+	h, err := NewHandler(
+		Config{
+			MaxSessions: -1,
+			Network: NetworkConfig{
+				Mode:  ExecutionPolicyFilter,
+				Allow: []string{"10.0.0.0/8", "192.168.1.1"},
+				Deny:  []string{"10.0.1.0/24"},
+			},
+		},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+
+	for ip, allowed := range map[string]bool{
+		"10.0.0.1":    true,
+		"10.0.1.1":    false,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"::1":         false,
+	} {
+		_, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP(ip)}, "test")
+		if allowed {
+			assert.NoError(t, err, ip)
+		} else {
+			assert.Error(t, err, ip)
+			assert.Equal(t, EConnectionRejected, err.(log.Message).Code())
+		}
+	}
+}
+
+func TestSourceOverrides(t *testing.T) {
+	h, err := NewHandler(
+		Config{
+			MaxSessions: -1,
+			TTY:         TTYConfig{Mode: ExecutionPolicyDisable},
+			Sources: []SourceOverrideConfig{
+				{
+					Networks: []string{"192.168.0.0/16"},
+					OverrideConfig: OverrideConfig{
+						TTY: &TTYConfig{Mode: ExecutionPolicyEnable},
+					},
+				},
+			},
+		},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+
+	office := openTestSession(t, h, "192.168.5.5", "office")
+	assert.NoError(t, office.OnPtyRequest(1, "xterm", 80, 25, 0, 0, []byte{}))
+
+	remote := openTestSession(t, h, "203.0.113.5", "remote")
+	err = remote.OnPtyRequest(1, "xterm", 80, 25, 0, 0, []byte{})
+	assert.Error(t, err)
+	labels := err.(log.Message).Labels()
+	assert.Equal(t, "203.0.113.5", labels["remoteAddr"])
+	assert.Equal(t, "remote", labels["connectionId"])
+	assert.Equal(t, "user", labels["username"])
+}
+
+func TestInvalidNetworkConfig(t *testing.T) {
+	assert.Error(t, Config{Network: NetworkConfig{Allow: []string{"10.0.0.0/33"}}}.Validate())
+	assert.Error(t, Config{Network: NetworkConfig{Deny: []string{"not-an-ip"}}}.Validate())
+	assert.Error(t, Config{Sources: []SourceOverrideConfig{{}}}.Validate())
+}
+
+func openTestSession(
+	t *testing.T,
+	h sshserver.Handler,
+	ip string,
+	connectionID string,
+) sshserver.SessionChannelHandler {
+	network, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP(ip)}, connectionID)
+	assert.NoError(t, err)
+	connection, err := network.OnHandshakeSuccess("user")
+	assert.NoError(t, err)
+	session, rejection := connection.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.Nil(t, rejection)
+	return session
+}
+
+type dummyHandler struct {
+}
+
+func (d *dummyHandler) OnReady() error {
+	return nil
+}
+
+func (d *dummyHandler) OnShutdown(_ context.Context) {
+}
+
+func (d *dummyHandler) OnNetworkConnection(_ net.TCPAddr, _ string) (sshserver.NetworkConnectionHandler, error) {
+	return &dummyNetworkBackend{}, nil
+}
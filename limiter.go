@@ -0,0 +1,111 @@
+package security
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LimitsConfig configures concurrency limits that are shared between all connections handled by the same security
+// handler. Contrary to MaxSessions these limits cannot be sidestepped by opening multiple connections. New is called
+// for each connection, so when using New instead of NewHandler the same Limiter must be passed to every call using
+// WithLimiter. New fails if the per-user limits are set without a Limiter.
+type LimitsConfig struct {
+	// MaxConnectionsPerUser limits how many connections a single user can have open at the same time. 0 or -1 means
+	// unlimited.
+	MaxConnectionsPerUser int `json:"maxConnectionsPerUser" yaml:"maxConnectionsPerUser"`
+	// MaxSessionsPerUser limits how many session channels a single user can have open at the same time across all of
+	// their connections. 0 or -1 means unlimited.
+	MaxSessionsPerUser int `json:"maxSessionsPerUser" yaml:"maxSessionsPerUser"`
+	// MaxConnectionsPerIP limits how many connections can be open from a single client IP address at the same time.
+	// 0 or -1 means unlimited. This limit only takes effect when using NewHandler.
+	MaxConnectionsPerIP int `json:"maxConnectionsPerIP" yaml:"maxConnectionsPerIP"`
+	// MaxSessionsPerIP limits how many session channels can be open from a single client IP address at the same time
+	// across all connections. 0 or -1 means unlimited. This limit only takes effect when using NewHandler.
+	MaxSessionsPerIP int `json:"maxSessionsPerIP" yaml:"maxSessionsPerIP"`
+}
+
+// Validate validates the limits configuration.
+func (l LimitsConfig) Validate() error {
+	if l.MaxConnectionsPerUser < -1 {
+		return fmt.Errorf("invalid maxConnectionsPerUser setting: %d", l.MaxConnectionsPerUser)
+	}
+	if l.MaxSessionsPerUser < -1 {
+		return fmt.Errorf("invalid maxSessionsPerUser setting: %d", l.MaxSessionsPerUser)
+	}
+	if l.MaxConnectionsPerIP < -1 {
+		return fmt.Errorf("invalid maxConnectionsPerIP setting: %d", l.MaxConnectionsPerIP)
+	}
+	if l.MaxSessionsPerIP < -1 {
+		return fmt.Errorf("invalid maxSessionsPerIP setting: %d", l.MaxSessionsPerIP)
+	}
+	return nil
+}
+
+// hasUserLimits returns true if a per-user limit is set.
+func (l LimitsConfig) hasUserLimits() bool {
+	return l.MaxConnectionsPerUser > 0 || l.MaxSessionsPerUser > 0
+}
+
+// Limiter keeps track of the connections and sessions per user and per IP address for the Limits configuration. It
+// is safe for concurrent use.
+type Limiter struct {
+	lock            *sync.Mutex
+	userConnections map[string]int
+	userSessions    map[string]int
+	ipConnections   map[string]int
+	ipSessions      map[string]int
+}
+
+// NewLimiter creates a Limiter that can be shared between handlers using WithLimiter.
+func NewLimiter() *Limiter {
+	return &Limiter{
+		lock:            &sync.Mutex{},
+		userConnections: map[string]int{},
+		userSessions:    map[string]int{},
+		ipConnections:   map[string]int{},
+		ipSessions:      map[string]int{},
+	}
+}
+
+// acquire takes a slot for the key if the limit permits. A limit of 0 or less means unlimited.
+func (l *Limiter) acquire(counts map[string]int, key string, limit int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if limit > 0 && counts[key] >= limit {
+		return false
+	}
+	counts[key]++
+	return true
+}
+
+// release frees up a slot for the key.
+func (l *Limiter) release(counts map[string]int, key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
+
+// acquireSession takes a session slot for both the user and the IP address. The IP address may be empty if it is not
+// known. It returns the code of the limit that was hit, or an empty string if the session is allowed.
+func (l *Limiter) acquireSession(limits LimitsConfig, username string, ip string) string {
+	if !l.acquire(l.userSessions, username, limits.MaxSessionsPerUser) {
+		return EMaxUserSessions
+	}
+	if ip != "" && !l.acquire(l.ipSessions, ip, limits.MaxSessionsPerIP) {
+		l.release(l.userSessions, username)
+		return EMaxIPSessions
+	}
+	return ""
+}
+
+// releaseSession frees up the session slots taken by acquireSession.
+func (l *Limiter) releaseSession(username string, ip string) {
+	l.release(l.userSessions, username)
+	if ip != "" {
+		l.release(l.ipSessions, ip)
+	}
+}
@@ -0,0 +1,175 @@
+package security
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/sshserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserConnectionLimit(t *testing.T) {
+	h, err := NewHandler(
+		Config{
+			MaxSessions: -1,
+			Limits: LimitsConfig{
+				MaxConnectionsPerUser: 2,
+			},
+		},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+
+	first, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, "1")
+	assert.NoError(t, err)
+	_, err = first.OnHandshakeSuccess("user")
+	assert.NoError(t, err)
+	second, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.2")}, "2")
+	assert.NoError(t, err)
+	_, err = second.OnHandshakeSuccess("user")
+	assert.NoError(t, err)
+
+	third, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.3")}, "3")
+	assert.NoError(t, err)
+	_, err = third.OnHandshakeSuccess("user")
+	assert.Error(t, err)
+	assert.Equal(t, EMaxUserConnections, err.(log.Message).Code())
+	third.OnDisconnect()
+
+	_, err = third.OnHandshakeSuccess("other")
+	assert.NoError(t, err)
+
+	first.OnDisconnect()
+	first.OnDisconnect()
+	fourth, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.4")}, "4")
+	assert.NoError(t, err)
+	_, err = fourth.OnHandshakeSuccess("user")
+	assert.NoError(t, err)
+	fifth, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.5")}, "5")
+	assert.NoError(t, err)
+	_, err = fifth.OnHandshakeSuccess("user")
+	assert.Error(t, err)
+}
+
+func TestIPConnectionLimit(t *testing.T) {
+	h, err := NewHandler(
+		Config{
+			Limits: LimitsConfig{
+				MaxConnectionsPerIP: 1,
+			},
+		},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+
+	first, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, "1")
+	assert.NoError(t, err)
+	_, err = h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, "2")
+	assert.Error(t, err)
+	assert.Equal(t, EMaxIPConnections, err.(log.Message).Code())
+	_, err = h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.2")}, "3")
+	assert.NoError(t, err)
+
+	first.OnDisconnect()
+	_, err = h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, "4")
+	assert.NoError(t, err)
+}
+
+func TestSessionLimitsAcrossConnections(t *testing.T) {
+	h, err := NewHandler(
+		Config{
+			MaxSessions: -1,
+			Limits: LimitsConfig{
+				MaxSessionsPerUser: 3,
+				MaxSessionsPerIP:   2,
+			},
+		},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+	)
+	assert.NoError(t, err)
+
+	first := openTestSession(t, h, "127.0.0.1", "1")
+	openTestSession(t, h, "127.0.0.1", "2")
+
+	network, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, "3")
+	assert.NoError(t, err)
+	connection, err := network.OnHandshakeSuccess("user")
+	assert.NoError(t, err)
+	_, rejection := connection.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.NotNil(t, rejection)
+	assert.Equal(t, EMaxIPSessions, rejection.Code())
+
+	openTestSession(t, h, "127.0.0.2", "4")
+	network, err = h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP("127.0.0.3")}, "5")
+	assert.NoError(t, err)
+	connection, err = network.OnHandshakeSuccess("user")
+	assert.NoError(t, err)
+	_, rejection = connection.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.NotNil(t, rejection)
+	assert.Equal(t, EMaxUserSessions, rejection.Code())
+
+	first.OnClose()
+	_, rejection = connection.OnSessionChannel(1, []byte{}, &sessionChannel{})
+	assert.Nil(t, rejection)
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	l := NewLimiter()
+	limits := LimitsConfig{MaxSessionsPerUser: 3, MaxSessionsPerIP: 2}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if l.acquireSession(limits, "user", "127.0.0.1") == "" {
+					l.releaseSession("user", "127.0.0.1")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Empty(t, l.userSessions)
+	assert.Empty(t, l.ipSessions)
+}
+
+func TestSharedLimiterWithNew(t *testing.T) {
+	config := Config{
+		MaxSessions: -1,
+		Limits: LimitsConfig{
+			MaxConnectionsPerUser: 2,
+			MaxSessionsPerUser:    1,
+		},
+	}
+	limiter := NewLimiter()
+	connect := func(opts ...Option) (sshserver.SSHConnectionHandler, error) {
+		network, err := New(config, &dummyNetworkBackend{}, log.NewTestLogger(t), opts...)
+		assert.NoError(t, err)
+		return network.OnHandshakeSuccess("user")
+	}
+
+	first, err := connect(WithLimiter(limiter))
+	assert.NoError(t, err)
+	second, err := connect(WithLimiter(limiter))
+	assert.NoError(t, err)
+	_, err = connect(WithLimiter(limiter))
+	assert.Error(t, err)
+	assert.Equal(t, EMaxUserConnections, err.(log.Message).Code())
+
+	_, rejection := first.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.Nil(t, rejection)
+	_, rejection = second.OnSessionChannel(0, []byte{}, &sessionChannel{})
+	assert.NotNil(t, rejection)
+
+	// Without a shared limiter every connection would be counted on its own.
+	_, err = New(config, &dummyNetworkBackend{}, log.NewTestLogger(t))
+	assert.Error(t, err)
+	config.Limits = LimitsConfig{MaxConnectionsPerIP: 1}
+	_, err = New(config, &dummyNetworkBackend{}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,154 @@
+package security
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// PatternPrefixGlob marks an allow or deny list entry as a shell-style glob pattern. The glob supports `*` (any
+	// sequence of characters), `?` (a single character), and character classes such as `[a-z]` or `[!0-9]`. The
+	// backslash escapes the following character. The pattern must match the whole value.
+	//
+	// Unlike shell globs, `*` and `?` also match `/` and spaces, so `glob:/usr/bin/*` matches `/usr/bin/local/git` and
+	// `glob:git *` matches `git push --force origin`. Use PatternPrefixPath for paths, and CommandRule to match the
+	// arguments of a command individually.
+	PatternPrefixGlob = "glob:"
+
+	// PatternPrefixPath marks an allow or deny list entry as a path glob. It works like PatternPrefixGlob, except that
+	// `*` and `?` do not match `/`. `**` matches any sequence of characters including `/`, e.g. `path:/usr/bin/*`
+	// matches `/usr/bin/git` but not `/usr/bin/local/git`, while `path:/usr/**` matches both.
+	PatternPrefixPath = "path:"
+
+	// PatternPrefixRegex marks an allow or deny list entry as a regular expression in the Go RE2 syntax. The expression
+	// is always anchored to the whole value, so `regex:LC_.*` will not match `XLC_ALL`.
+	PatternPrefixRegex = "regex:"
+)
+
+// matcher matches a single value against a compiled allow or deny list entry.
+type matcher func(value string) bool
+
+// compilePattern compiles an allow or deny list entry. Entries without a known prefix are matched exactly.
+func compilePattern(pattern string) (matcher, error) {
+	switch {
+	case strings.HasPrefix(pattern, PatternPrefixGlob):
+		return compileGlob(pattern, strings.TrimPrefix(pattern, PatternPrefixGlob), false)
+	case strings.HasPrefix(pattern, PatternPrefixPath):
+		return compileGlob(pattern, strings.TrimPrefix(pattern, PatternPrefixPath), true)
+	case strings.HasPrefix(pattern, PatternPrefixRegex):
+		re, err := regexp.Compile("^(?:" + strings.TrimPrefix(pattern, PatternPrefixRegex) + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q (%w)", pattern, err)
+		}
+		return re.MatchString, nil
+	default:
+		return func(value string) bool {
+			return value == pattern
+		}, nil
+	}
+}
+
+func compileGlob(pattern string, glob string, path bool) (matcher, error) {
+	expression, err := globToRegexp(glob, path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q (%w)", pattern, err)
+	}
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q (%w)", pattern, err)
+	}
+	return re.MatchString, nil
+}
+
+// globToRegexp converts a shell-style glob into an anchored regular expression. If path is set `*` and `?` do not
+// match `/`, and `**` matches any sequence of characters.
+func globToRegexp(glob string, path bool) (string, error) {
+	result := strings.Builder{}
+	result.WriteString("^")
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			switch {
+			case !path:
+				result.WriteString("(?s:.*)")
+			case i+1 < len(runes) && runes[i+1] == '*':
+				result.WriteString("(?s:.*)")
+				i++
+			default:
+				result.WriteString("[^/]*")
+			}
+		case '?':
+			if path {
+				result.WriteString("[^/]")
+			} else {
+				result.WriteString("(?s:.)")
+			}
+		case '\\':
+			i++
+			if i == len(runes) {
+				return "", fmt.Errorf("trailing escape character")
+			}
+			result.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			end := i + 1
+			if end < len(runes) && (runes[end] == '!' || runes[end] == '^') {
+				end++
+			}
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return "", fmt.Errorf("unterminated character class at position %d", i)
+			}
+			class := runes[i+1 : end]
+			result.WriteString("[")
+			if len(class) > 0 && (class[0] == '!' || class[0] == '^') {
+				result.WriteString("^")
+				class = class[1:]
+			}
+			for _, r := range class {
+				if r == '\\' || r == '[' || r == ']' {
+					result.WriteRune('\\')
+				}
+				result.WriteRune(r)
+			}
+			result.WriteString("]")
+			i = end
+		default:
+			result.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	result.WriteString("$")
+	return result.String(), nil
+}
+
+// validatePatterns compiles all entries of an allow or deny list and reports the first invalid entry.
+func validatePatterns(patterns []string) error {
+	for i, pattern := range patterns {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// matchAny returns the first entry of the list that matches the value. The entries of the configurations applied by
+// New, NewHandler and ConfigHolder are validated and compiled beforehand. Only configurations that bypass these can
+// contain invalid entries, which never match.
+func (p *parsedConfig) matchAny(patterns []string, value string) (string, bool) {
+	for _, pattern := range patterns {
+		m, err := p.matcher(pattern)
+		if err != nil {
+			continue
+		}
+		if m(value) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,107 @@
+package security
+
+import (
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternMatching(t *testing.T) {
+	var parsed *parsedConfig
+	patterns := []string{"EXACT", "glob:LC_*", "regex:GIT_[A-Z]+", "glob:file[0-9].t?t", "glob:literal\\*"}
+
+	for value, expected := range map[string]string{
+		"EXACT":        "EXACT",
+		"LC_ALL":       "glob:LC_*",
+		"LC_":          "glob:LC_*",
+		"GIT_DIR":      "regex:GIT_[A-Z]+",
+		"file1.txt":    "glob:file[0-9].t?t",
+		"literal*":     "glob:literal\\*",
+		"EXACTLY":      "",
+		"XLC_ALL":      "",
+		"XGIT_DIR":     "",
+		"GIT_DIR_X":    "",
+		"fileA.txt":    "",
+		"literalvalue": "",
+	} {
+		matched, ok := parsed.matchAny(patterns, value)
+		assert.Equal(t, expected != "", ok, value)
+		assert.Equal(t, expected, matched, value)
+	}
+}
+
+func TestGlobMatchesSlashes(t *testing.T) {
+	var parsed *parsedConfig
+	_, ok := parsed.matchAny([]string{"glob:/usr/bin/*"}, "/usr/bin/local/git")
+	assert.True(t, ok)
+}
+
+func TestPathPattern(t *testing.T) {
+	var parsed *parsedConfig
+	for value, expected := range map[string]bool{
+		"/usr/bin/git":       true,
+		"/usr/bin/local/git": false,
+		"/usr/bin/":          true,
+		"/usr/bin/a/b":       false,
+	} {
+		_, ok := parsed.matchAny([]string{"path:/usr/bin/*"}, value)
+		assert.Equal(t, expected, ok, value)
+	}
+	_, ok := parsed.matchAny([]string{"path:/usr/**"}, "/usr/bin/local/git")
+	assert.True(t, ok)
+	_, ok = parsed.matchAny([]string{"path:/usr/bin/gi?"}, "/usr/bin/gi/")
+	assert.False(t, ok)
+	assert.Error(t, validatePatterns([]string{"path:[a-z"}))
+}
+
+func TestPatternsAreCompiledOnParse(t *testing.T) {
+	config := Config{
+		Env: EnvConfig{Allow: []string{"glob:LC_*"}},
+		Users: map[string]OverrideConfig{
+			"alice": {Subsystem: &SubsystemConfig{Deny: []string{"regex:sftp|scp"}}},
+		},
+		Groups: []GroupOverrideConfig{
+			{Group: "ops", OverrideConfig: OverrideConfig{Signal: &SignalConfig{Allow: []string{"glob:SIG*"}}}},
+		},
+	}.parse()
+	for _, pattern := range []string{"glob:LC_*", "regex:sftp|scp", "glob:SIG*"} {
+		_, ok := config.parsed.matchers[pattern]
+		assert.True(t, ok, pattern)
+	}
+
+	resolved, _ := config.resolve("alice", nil, nil)
+	assert.Same(t, config.parsed, resolved.parsed)
+	rule, ok := resolved.parsed.matchAny(resolved.Subsystem.Deny, "scp")
+	assert.True(t, ok)
+	assert.Equal(t, "regex:sftp|scp", rule)
+}
+
+func TestConfigIsParsedWhenApplied(t *testing.T) {
+	config := Config{Env: EnvConfig{Allow: []string{"glob:LC_*"}}}
+
+	h, err := NewHandler(config, &dummyHandler{}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	assert.Contains(t, h.(*handler).config.parsed.matchers, "glob:LC_*")
+
+	holder, err := NewConfigHolder(config, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	assert.Contains(t, holder.Get().parsed.matchers, "glob:LC_*")
+	config.Env.Allow = []string{"glob:LANG*"}
+	assert.NoError(t, holder.Reload(config))
+	assert.Contains(t, holder.Get().parsed.matchers, "glob:LANG*")
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	assert.NoError(t, validatePatterns([]string{"[not-a-pattern", "glob:[!a-z]", "regex:a|b"}))
+	assert.Error(t, validatePatterns([]string{"glob:[a-z"}))
+	assert.Error(t, validatePatterns([]string{"glob:trailing\\"}))
+	assert.Error(t, validatePatterns([]string{"regex:(unclosed"}))
+
+	config := Config{
+		Env: EnvConfig{
+			Deny: []string{"regex:(unclosed"},
+		},
+	}
+	assert.Error(t, config.Validate())
+}
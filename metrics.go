@@ -0,0 +1,211 @@
+package security
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MetricsCollector receives the policy decisions and the session lifecycle events of the security layer. The built-in
+// implementation is returned by NewMetrics.
+type MetricsCollector interface {
+	DecisionRecorder
+
+	// SessionOpened is called when a session channel is opened on a connection.
+	SessionOpened(connectionID string)
+	// SessionClosed is called when a session channel opened on a connection is closed.
+	SessionClosed(connectionID string)
+}
+
+// NewMetrics creates a MetricsCollector that keeps the metrics in memory and can write them in the Prometheus text
+// exposition format.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		lock:                     &sync.Mutex{},
+		decisions:                map[decisionMetricKey]uint64{},
+		connectionActiveSessions: map[string]int64{},
+	}
+}
+
+// Metrics maintains a counter of policy decisions per request type, outcome and message code, a gauge of active
+// sessions and a gauge of active sessions per connection. It implements http.Handler to expose the metrics for
+// scraping.
+//
+// Request types are chosen by the client for global requests, channel types and unsupported channel requests. To
+// keep the number of time series bounded, all request types the security layer does not know are counted under
+// MetricRequestTypeOther. For the same reason a connection is only reported while it has active sessions, so the
+// number of per-connection series never exceeds the number of open connections.
+type Metrics struct {
+	lock                     *sync.Mutex
+	decisions                map[decisionMetricKey]uint64
+	activeSessions           int64
+	connectionActiveSessions map[string]int64
+}
+
+type decisionMetricKey struct {
+	requestType string
+	outcome     DecisionOutcome
+	code        string
+}
+
+// MetricDecisions is the name of the counter of policy decisions.
+const MetricDecisions = "containerssh_security_decisions_total"
+
+// MetricActiveSessions is the name of the gauge of active sessions.
+const MetricActiveSessions = "containerssh_security_active_sessions"
+
+// MetricConnectionActiveSessions is the name of the gauge of active sessions per connection.
+const MetricConnectionActiveSessions = "containerssh_security_connection_active_sessions"
+
+// MetricRequestTypeOther is the request_type label of the decisions about request types the security layer does not
+// know.
+const MetricRequestTypeOther = "other"
+
+// metricRequestTypes are the request types that get their own request_type label.
+var metricRequestTypes = map[string]bool{
+	"connection":                 true,
+	"handshake":                  true,
+	"session":                    true,
+	"env":                        true,
+	"pty-req":                    true,
+	"window-change":              true,
+	"exec":                       true,
+	"shell":                      true,
+	"subsystem":                  true,
+	"signal":                     true,
+	"x11-req":                    true,
+	"auth-agent-req@openssh.com": true,
+	"direct-tcpip":               true,
+	"tcpip-forward":              true,
+	"cancel-tcpip-forward":       true,
+}
+
+// metricRequestType returns the request_type label for the request type.
+func metricRequestType(requestType string) string {
+	if metricRequestTypes[requestType] {
+		return requestType
+	}
+	return MetricRequestTypeOther
+}
+
+// RecordDecision increments the decision counter for the request type, outcome and code of the decision.
+func (m *Metrics) RecordDecision(decision Decision) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.decisions[decisionMetricKey{
+		requestType: metricRequestType(decision.RequestType),
+		outcome:     decision.Outcome,
+		code:        decision.Code,
+	}]++
+}
+
+// SessionOpened increments the active sessions gauge and the gauge of the connection. Sessions without a connection
+// ID, e.g. the ones created by New, are only counted in the active sessions gauge.
+func (m *Metrics) SessionOpened(connectionID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.activeSessions++
+	if connectionID != "" {
+		m.connectionActiveSessions[connectionID]++
+	}
+}
+
+// SessionClosed decrements the active sessions gauge and the gauge of the connection. The gauge of the connection is
+// removed when its last session is closed.
+func (m *Metrics) SessionClosed(connectionID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.activeSessions > 0 {
+		m.activeSessions--
+	}
+	if count, ok := m.connectionActiveSessions[connectionID]; ok {
+		if count <= 1 {
+			delete(m.connectionActiveSessions, connectionID)
+		} else {
+			m.connectionActiveSessions[connectionID] = count - 1
+		}
+	}
+}
+
+// Decisions returns the current value of the decision counter for the request type, outcome and code. Request types
+// without their own label are reported under MetricRequestTypeOther.
+func (m *Metrics) Decisions(requestType string, outcome DecisionOutcome, code string) uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.decisions[decisionMetricKey{requestType: metricRequestType(requestType), outcome: outcome, code: code}]
+}
+
+// ActiveSessions returns the current number of active sessions.
+func (m *Metrics) ActiveSessions() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.activeSessions
+}
+
+// ConnectionActiveSessions returns the current number of active sessions on the connection.
+func (m *Metrics) ConnectionActiveSessions(connectionID string) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.connectionActiveSessions[connectionID]
+}
+
+// WriteTo writes the metrics to the writer in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(writer io.Writer) (int64, error) {
+	builder := &strings.Builder{}
+	m.lock.Lock()
+	decisionLines := make([]string, 0, len(m.decisions))
+	for key, value := range m.decisions {
+		decisionLines = append(decisionLines, fmt.Sprintf(
+			"%s{request_type=\"%s\",outcome=\"%s\",code=\"%s\"} %d\n",
+			MetricDecisions,
+			escapeLabelValue(key.requestType),
+			escapeLabelValue(string(key.outcome)),
+			escapeLabelValue(key.code),
+			value,
+		))
+	}
+	activeSessions := m.activeSessions
+	connectionLines := make([]string, 0, len(m.connectionActiveSessions))
+	for connectionID, value := range m.connectionActiveSessions {
+		connectionLines = append(connectionLines, fmt.Sprintf(
+			"%s{connection_id=\"%s\"} %d\n",
+			MetricConnectionActiveSessions,
+			escapeLabelValue(connectionID),
+			value,
+		))
+	}
+	m.lock.Unlock()
+	sort.Strings(decisionLines)
+	sort.Strings(connectionLines)
+
+	builder.WriteString("# HELP " + MetricDecisions + " Number of policy decisions by request type, outcome and code.\n")
+	builder.WriteString("# TYPE " + MetricDecisions + " counter\n")
+	for _, line := range decisionLines {
+		builder.WriteString(line)
+	}
+	builder.WriteString("# HELP " + MetricActiveSessions + " Number of active sessions.\n")
+	builder.WriteString("# TYPE " + MetricActiveSessions + " gauge\n")
+	builder.WriteString(fmt.Sprintf("%s %d\n", MetricActiveSessions, activeSessions))
+	builder.WriteString(
+		"# HELP " + MetricConnectionActiveSessions + " Number of active sessions of connections with open sessions.\n",
+	)
+	builder.WriteString("# TYPE " + MetricConnectionActiveSessions + " gauge\n")
+	for _, line := range connectionLines {
+		builder.WriteString(line)
+	}
+	n, err := io.WriteString(writer, builder.String())
+	return int64(n), err
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(writer)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,114 @@
+package security
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	h, err := NewHandler(
+		Config{
+			MaxSessions: -1,
+			Command: CommandConfig{
+				Mode: ExecutionPolicyDisable,
+			},
+		},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+		WithMetrics(metrics),
+	)
+	assert.NoError(t, err)
+
+	session := openTestSession(t, h, "127.0.0.1", "conn1")
+	assert.Equal(t, int64(1), metrics.ActiveSessions())
+	assert.Equal(t, int64(1), metrics.ConnectionActiveSessions("conn1"))
+	assert.Error(t, session.OnExecRequest(1, "/bin/bash"))
+	assert.Error(t, session.OnExecRequest(2, "/bin/sh"))
+	assert.Equal(t, uint64(2), metrics.Decisions("exec", DecisionOutcomeDeny, EExecRejected))
+	assert.Equal(t, uint64(1), metrics.Decisions("session", DecisionOutcomeAllow, ""))
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	assert.True(t, strings.Contains(body, "# TYPE containerssh_security_decisions_total counter\n"))
+	assert.True(t, strings.Contains(
+		body,
+		"containerssh_security_decisions_total{request_type=\"exec\",outcome=\"deny\",code=\""+EExecRejected+"\"} 2\n",
+	))
+	assert.True(t, strings.Contains(body, "containerssh_security_active_sessions 1\n"))
+	assert.True(t, strings.Contains(body, "containerssh_security_connection_active_sessions{connection_id=\"conn1\"} 1\n"))
+
+	session.OnClose()
+	session.OnClose()
+	assert.Equal(t, int64(0), metrics.ActiveSessions())
+	builder := &strings.Builder{}
+	_, err = metrics.WriteTo(builder)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(builder.String(), "containerssh_security_active_sessions 0\n"))
+	assert.Equal(t, int64(0), metrics.ConnectionActiveSessions("conn1"))
+	assert.False(t, strings.Contains(builder.String(), "conn1"))
+}
+
+func TestMetricsConnectionActiveSessions(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.SessionOpened("conn1")
+	metrics.SessionOpened("conn1")
+	metrics.SessionOpened("conn2")
+	metrics.SessionOpened("")
+	assert.Equal(t, int64(4), metrics.ActiveSessions())
+	assert.Equal(t, int64(2), metrics.ConnectionActiveSessions("conn1"))
+	assert.Equal(t, int64(1), metrics.ConnectionActiveSessions("conn2"))
+
+	metrics.SessionClosed("conn1")
+	metrics.SessionClosed("conn2")
+	metrics.SessionClosed("")
+	builder := &strings.Builder{}
+	_, err := metrics.WriteTo(builder)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(builder.String(), "containerssh_security_active_sessions 1\n"))
+	assert.True(
+		t,
+		strings.Contains(builder.String(), "containerssh_security_connection_active_sessions{connection_id=\"conn1\"} 1\n"),
+	)
+	assert.False(t, strings.Contains(builder.String(), "conn2"))
+
+	metrics.SessionClosed("conn1")
+	assert.Equal(t, int64(0), metrics.ActiveSessions())
+	assert.Equal(t, 0, len(metrics.connectionActiveSessions))
+}
+
+func TestMetricsUnknownRequestTypes(t *testing.T) {
+	metrics := NewMetrics()
+	for i := 0; i < 100; i++ {
+		metrics.RecordDecision(Decision{
+			RequestType: fmt.Sprintf("client-chosen-%d@example.com", i),
+			Outcome:     DecisionOutcomeDeny,
+			Code:        EGlobalRequestRejected,
+		})
+	}
+	metrics.RecordDecision(Decision{RequestType: "exec", Outcome: DecisionOutcomeAllow})
+	assert.Equal(t, uint64(100), metrics.Decisions(MetricRequestTypeOther, DecisionOutcomeDeny, EGlobalRequestRejected))
+	assert.Equal(t, uint64(100), metrics.Decisions("anything-else", DecisionOutcomeDeny, EGlobalRequestRejected))
+	assert.Equal(t, uint64(1), metrics.Decisions("exec", DecisionOutcomeAllow, ""))
+
+	builder := &strings.Builder{}
+	_, err := metrics.WriteTo(builder)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(builder.String(), "containerssh_security_decisions_total{"))
+	assert.False(t, strings.Contains(builder.String(), "client-chosen"))
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.RecordDecision(Decision{RequestType: "exec", Outcome: DecisionOutcomeAllow, Code: "foo\"bar\\\n"})
+	builder := &strings.Builder{}
+	_, err := metrics.WriteTo(builder)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(builder.String(), `code="foo\"bar\\\n"`))
+}
@@ -0,0 +1,124 @@
+package security
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NetworkConfig controls which client networks are allowed to connect. It only takes effect when the security layer
+// wraps the sshserver.Handler using NewHandler since the network connection handler never sees the client address.
+type NetworkConfig struct {
+	// Mode configures how to treat incoming connections. Unlike the other sections this section does not fall back to
+	// DefaultMode, an unconfigured mode behaves like ExecutionPolicyEnable.
+	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Allow takes effect when Mode is ExecutionPolicyFilter and only allows clients from the specified networks to
+	// connect. Entries are CIDR blocks (e.g. `10.0.0.0/8`) or single IP addresses.
+	Allow []string `json:"allow" yaml:"allow"`
+	// Deny takes effect when Mode is not ExecutionPolicyDisable and rejects clients from the specified networks.
+	Deny []string `json:"deny" yaml:"deny"`
+}
+
+// Validate validates the network configuration.
+func (n NetworkConfig) Validate() error {
+	if err := n.Mode.Validate(); err != nil {
+		return fmt.Errorf("invalid mode (%w)", err)
+	}
+	if _, err := parseNetworks(n.Allow); err != nil {
+		return fmt.Errorf("invalid allow list (%w)", err)
+	}
+	if _, err := parseNetworks(n.Deny); err != nil {
+		return fmt.Errorf("invalid deny list (%w)", err)
+	}
+	return nil
+}
+
+// SourceOverrideConfig is a policy override applied to clients connecting from specific networks.
+type SourceOverrideConfig struct {
+	// Networks contains the CIDR blocks or IP addresses this override applies to.
+	Networks []string `json:"networks" yaml:"networks"`
+
+	OverrideConfig `yaml:",inline"`
+}
+
+// Validate validates the source override.
+func (s SourceOverrideConfig) Validate() error {
+	if len(s.Networks) == 0 {
+		return fmt.Errorf("no networks specified")
+	}
+	if _, err := parseNetworks(s.Networks); err != nil {
+		return fmt.Errorf("invalid networks (%w)", err)
+	}
+	return s.OverrideConfig.Validate()
+}
+
+// parseNetworks parses a list of CIDR blocks and single IP addresses.
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, len(networks))
+	for i, network := range networks {
+		ipNet, err := parseNetwork(network)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		result[i] = ipNet
+	}
+	return result, nil
+}
+
+// parseNetwork parses a CIDR block or a single IP address.
+func parseNetwork(network string) (*net.IPNet, error) {
+	if !strings.Contains(network, "/") {
+		ip := net.ParseIP(network)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", network)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, err
+	}
+	return ipNet, nil
+}
+
+// matchNetwork returns the first network in the list that contains the IP address. Invalid entries never match.
+func (p *parsedConfig) matchNetwork(networks []string, ip net.IP) (string, bool) {
+	if ip == nil {
+		return "", false
+	}
+	for _, network := range networks {
+		ipNet, err := p.network(network)
+		if err != nil {
+			continue
+		}
+		if ipNet.Contains(ip) {
+			return network, true
+		}
+	}
+	return "", false
+}
+
+// checkConnection evaluates the network policy for a client address. It returns the list entry that decided the
+// outcome, if any, and the reason for the rejection, or an empty string if the connection is allowed.
+func (n NetworkConfig) checkConnection(parsed *parsedConfig, ip net.IP) (rule string, reason string) {
+	switch n.Mode {
+	case ExecutionPolicyDisable:
+		return "", "Incoming connections are disabled in the security settings."
+	case ExecutionPolicyFilter:
+		var ok bool
+		if rule, ok = parsed.matchNetwork(n.Allow, ip); !ok {
+			return "", "The client address does not match the network allow list."
+		}
+		fallthrough
+	default:
+		if denied, ok := parsed.matchNetwork(n.Deny, ip); ok {
+			return denied, "The client address matches the network deny list."
+		}
+	}
+	return rule, ""
+}
@@ -0,0 +1,126 @@
+package security
+
+// Option is a functional option for New.
+type Option func(o *options)
+
+type options struct {
+	groupResolver     GroupResolver
+	decisionRecorders []DecisionRecorder
+	metrics           MetricsCollector
+	configHolder      *ConfigHolder
+	sessionRegistry   *SessionRegistry
+	clock             Clock
+	limiter           *Limiter
+}
+
+// WithGroupResolver sets the resolver used to look up the groups of a user when applying group overrides. Without a
+// resolver group overrides are never applied.
+func WithGroupResolver(resolver GroupResolver) Option {
+	return func(o *options) {
+		o.groupResolver = resolver
+	}
+}
+
+// WithDecisionRecorder adds a recorder that receives every policy decision. The option may be passed multiple times to
+// record decisions to several sinks.
+func WithDecisionRecorder(recorder DecisionRecorder) Option {
+	return func(o *options) {
+		o.decisionRecorders = append(o.decisionRecorders, recorder)
+	}
+}
+
+// WithMetrics sets the collector that receives the decision counters and the active sessions gauge.
+func WithMetrics(metrics MetricsCollector) Option {
+	return func(o *options) {
+		o.metrics = metrics
+		o.decisionRecorders = append(o.decisionRecorders, metrics)
+	}
+}
+
+// WithConfigHolder makes the security layer read the configuration from the holder, allowing it to be reloaded at
+// runtime. When a holder is set, the config argument of New and NewHandler is ignored and only the configuration of
+// the holder is used.
+func WithConfigHolder(holder *ConfigHolder) Option {
+	return func(o *options) {
+		o.configHolder = holder
+	}
+}
+
+// WithSessionRegistry adds the live connections and sessions to the registry, allowing them to be listed and closed.
+func WithSessionRegistry(registry *SessionRegistry) Option {
+	return func(o *options) {
+		o.sessionRegistry = registry
+	}
+}
+
+// WithLimiter shares the connection and session counters of the Limits configuration with every handler created with
+// the same Limiter. New is called for each connection, so it requires this option if the per-user limits are set.
+func WithLimiter(limiter *Limiter) Option {
+	return func(o *options) {
+		o.limiter = limiter
+	}
+}
+
+// getLimiter returns the shared limiter, or a new one owned by the handler if none is configured.
+func (o options) getLimiter() *Limiter {
+	if o.limiter != nil {
+		return o.limiter
+	}
+	return NewLimiter()
+}
+
+// WithClock replaces the source of time used for timeouts and timestamps. This is intended for testing.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// getClock returns the configured clock or the system clock.
+func (o options) getClock() Clock {
+	if o.clock != nil {
+		return o.clock
+	}
+	return realClock{}
+}
+
+// sessionRegistries returns the registries live connections must be added to.
+func (o options) sessionRegistries() []*SessionRegistry {
+	var result []*SessionRegistry
+	if o.configHolder != nil {
+		result = append(result, o.configHolder.sessions)
+	}
+	if o.sessionRegistry != nil {
+		result = append(result, o.sessionRegistry)
+	}
+	return result
+}
+
+// currentConfig returns the configuration from the config holder if set, or the passed configuration otherwise.
+func (o options) currentConfig(config Config) Config {
+	if o.configHolder != nil {
+		return o.configHolder.Get()
+	}
+	return config
+}
+
+// resolveGroups looks up the groups of the user if the configuration contains group overrides and a group resolver is
+// configured. The second return value indicates whether the groups were looked up.
+func (o options) resolveGroups(config Config, username string) ([]string, bool, error) {
+	if len(config.Groups) == 0 || o.groupResolver == nil {
+		return nil, false, nil
+	}
+	groups, err := o.groupResolver.ResolveGroups(username)
+	if err != nil {
+		return nil, false, err
+	}
+	return groups, true, nil
+}
+
+func newOptions(opts []Option) options {
+	result := options{}
+	for _, opt := range opts {
+		opt(&result)
+	}
+	return result
+}
@@ -0,0 +1,280 @@
+package security
+
+import (
+	"fmt"
+	"net"
+)
+
+// OverrideConfig contains policy settings that replace the base configuration for specific users or groups. Every
+// section that is set replaces the corresponding section of the base configuration as a whole, unset (nil or empty)
+// sections leave the base configuration unchanged.
+type OverrideConfig struct {
+	// DefaultMode overrides the default execution policy if set.
+	DefaultMode ExecutionPolicy `json:"defaultMode,omitempty" yaml:"defaultMode,omitempty"`
+	// Enforcement overrides the global enforcement mode if set.
+	Enforcement EnforcementMode `json:"enforcement,omitempty" yaml:"enforcement,omitempty"`
+	// Sequencing overrides the request sequencing mode if set.
+	Sequencing SequencingMode `json:"sequencing,omitempty" yaml:"sequencing,omitempty"`
+	// ForceCommand overrides the forced command if set. Set it to an empty string to remove a globally forced command.
+	ForceCommand *string `json:"forceCommand,omitempty" yaml:"forceCommand,omitempty"`
+	// Env overrides the environment variable policy.
+	Env *EnvConfig `json:"env,omitempty" yaml:"env,omitempty"`
+	// Command overrides the command execution policy.
+	Command *CommandConfig `json:"command,omitempty" yaml:"command,omitempty"`
+	// Shell overrides the shell policy.
+	Shell *ShellConfig `json:"shell,omitempty" yaml:"shell,omitempty"`
+	// Subsystem overrides the subsystem policy.
+	Subsystem *SubsystemConfig `json:"subsystem,omitempty" yaml:"subsystem,omitempty"`
+	// TTY overrides the TTY policy.
+	TTY *TTYConfig `json:"tty,omitempty" yaml:"tty,omitempty"`
+	// Signal overrides the signal policy.
+	Signal *SignalConfig `json:"signal,omitempty" yaml:"signal,omitempty"`
+	// Forwarding overrides the port forwarding policy.
+	Forwarding *ForwardingConfig `json:"forwarding,omitempty" yaml:"forwarding,omitempty"`
+	// X11 overrides the X11 forwarding policy.
+	X11 *X11Config `json:"x11,omitempty" yaml:"x11,omitempty"`
+	// AgentForwarding overrides the agent forwarding policy.
+	AgentForwarding *AgentForwardingConfig `json:"agentForwarding,omitempty" yaml:"agentForwarding,omitempty"`
+	// Unsupported overrides the policy for channel types and requests without a dedicated section.
+	Unsupported *UnsupportedConfig `json:"unsupported,omitempty" yaml:"unsupported,omitempty"`
+	// MaxSessions overrides the maximum number of sessions per connection.
+	MaxSessions *int `json:"maxSessions,omitempty" yaml:"maxSessions,omitempty"`
+	// Quota overrides the data transfer quotas.
+	Quota *QuotaConfig `json:"quota,omitempty" yaml:"quota,omitempty"`
+}
+
+// Validate validates the override configuration.
+func (o OverrideConfig) Validate() error {
+	if err := o.DefaultMode.Validate(); err != nil {
+		return fmt.Errorf("invalid defaultMode configuration (%w)", err)
+	}
+	if err := o.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement configuration (%w)", err)
+	}
+	if err := o.Sequencing.Validate(); err != nil {
+		return fmt.Errorf("invalid sequencing configuration (%w)", err)
+	}
+	if o.ForceCommand != nil {
+		if err := validateForceCommand(*o.ForceCommand); err != nil {
+			return err
+		}
+	}
+	if o.Env != nil {
+		if err := o.Env.Validate(); err != nil {
+			return fmt.Errorf("invalid env configuration (%w)", err)
+		}
+	}
+	if o.Command != nil {
+		if err := o.Command.Validate(); err != nil {
+			return fmt.Errorf("invalid command configuration (%w)", err)
+		}
+	}
+	if o.Shell != nil {
+		if err := o.Shell.Validate(); err != nil {
+			return fmt.Errorf("invalid shell configuration (%w)", err)
+		}
+	}
+	if o.Subsystem != nil {
+		if err := o.Subsystem.Validate(); err != nil {
+			return fmt.Errorf("invalid subsystem configuration (%w)", err)
+		}
+	}
+	if o.TTY != nil {
+		if err := o.TTY.Validate(); err != nil {
+			return fmt.Errorf("invalid TTY configuration (%w)", err)
+		}
+	}
+	if o.Signal != nil {
+		if err := o.Signal.Validate(); err != nil {
+			return fmt.Errorf("invalid signal configuration (%w)", err)
+		}
+	}
+	if o.Forwarding != nil {
+		if err := o.Forwarding.Validate(); err != nil {
+			return fmt.Errorf("invalid forwarding configuration (%w)", err)
+		}
+	}
+	if o.X11 != nil {
+		if err := o.X11.Validate(); err != nil {
+			return fmt.Errorf("invalid X11 configuration (%w)", err)
+		}
+	}
+	if o.AgentForwarding != nil {
+		if err := o.AgentForwarding.Validate(); err != nil {
+			return fmt.Errorf("invalid agent forwarding configuration (%w)", err)
+		}
+	}
+	if o.Unsupported != nil {
+		if err := o.Unsupported.Validate(); err != nil {
+			return fmt.Errorf("invalid unsupported configuration (%w)", err)
+		}
+	}
+	if o.MaxSessions != nil && *o.MaxSessions < -1 {
+		return fmt.Errorf("invalid maxSessions setting: %d", *o.MaxSessions)
+	}
+	if o.Quota != nil {
+		if err := o.Quota.Validate(); err != nil {
+			return fmt.Errorf("invalid quota configuration (%w)", err)
+		}
+	}
+	return nil
+}
+
+// applyTo replaces the sections of the config that are set in the override.
+func (o OverrideConfig) applyTo(config *Config) {
+	if o.DefaultMode != ExecutionPolicyUnconfigured {
+		config.DefaultMode = o.DefaultMode
+	}
+	if o.Enforcement != EnforcementModeUnconfigured {
+		config.Enforcement = o.Enforcement
+	}
+	if o.Sequencing != SequencingModeUnconfigured {
+		config.Sequencing = o.Sequencing
+	}
+	if o.ForceCommand != nil {
+		config.ForceCommand = *o.ForceCommand
+	}
+	if o.Env != nil {
+		config.Env = *o.Env
+	}
+	if o.Command != nil {
+		config.Command = *o.Command
+	}
+	if o.Shell != nil {
+		config.Shell = *o.Shell
+	}
+	if o.Subsystem != nil {
+		config.Subsystem = *o.Subsystem
+	}
+	if o.TTY != nil {
+		config.TTY = *o.TTY
+	}
+	if o.Signal != nil {
+		config.Signal = *o.Signal
+	}
+	if o.Forwarding != nil {
+		config.Forwarding = *o.Forwarding
+	}
+	if o.X11 != nil {
+		config.X11 = *o.X11
+	}
+	if o.AgentForwarding != nil {
+		config.AgentForwarding = *o.AgentForwarding
+	}
+	if o.Unsupported != nil {
+		config.Unsupported = *o.Unsupported
+	}
+	if o.MaxSessions != nil {
+		config.MaxSessions = *o.MaxSessions
+	}
+	if o.Quota != nil {
+		config.Quota = *o.Quota
+	}
+}
+
+// GroupOverrideConfig is a policy override applied to all members of a group.
+type GroupOverrideConfig struct {
+	// Group is the name of the group as returned by the GroupResolver.
+	Group string `json:"group" yaml:"group"`
+
+	OverrideConfig `yaml:",inline"`
+}
+
+// Validate validates the group override.
+func (g GroupOverrideConfig) Validate() error {
+	if g.Group == "" {
+		return fmt.Errorf("no group name specified")
+	}
+	return g.OverrideConfig.Validate()
+}
+
+// GroupResolver resolves the groups of an authenticated user so group overrides can be applied.
+type GroupResolver interface {
+	// ResolveGroups returns the names of the groups the user is a member of.
+	ResolveGroups(username string) ([]string, error)
+}
+
+// GroupResolverFunc is a function implementing the GroupResolver interface.
+type GroupResolverFunc func(username string) ([]string, error)
+
+// ResolveGroups calls the underlying function.
+func (f GroupResolverFunc) ResolveGroups(username string) ([]string, error) {
+	return f(username)
+}
+
+// validateOverrides checks the user and group overrides for invalid and conflicting entries.
+func (c Config) validateOverrides() error {
+	for username, override := range c.Users {
+		if username == "" {
+			return fmt.Errorf("user override with empty username")
+		}
+		if err := override.Validate(); err != nil {
+			return fmt.Errorf("invalid override for user %s (%w)", username, err)
+		}
+	}
+	groups := map[string]int{}
+	for i, group := range c.Groups {
+		if err := group.Validate(); err != nil {
+			return fmt.Errorf("invalid group override %d (%w)", i, err)
+		}
+		if previous, ok := groups[group.Group]; ok {
+			return fmt.Errorf(
+				"conflicting overrides for group %s (entries %d and %d), merge them into a single entry",
+				group.Group,
+				previous,
+				i,
+			)
+		}
+		groups[group.Group] = i
+	}
+	for i, source := range c.Sources {
+		if err := source.Validate(); err != nil {
+			return fmt.Errorf("invalid source override %d (%w)", i, err)
+		}
+	}
+	return nil
+}
+
+// resolve returns the effective configuration for a user. The precedence order is, from lowest to highest:
+//
+//  1. The base configuration.
+//  2. Source overrides matching the client address in the order they are listed in Sources.
+//  3. Group overrides in the order they are listed in Groups. If a user is a member of multiple groups the entry listed
+//     later wins for every section both entries set.
+//  4. The user override from Users.
+//
+// Every override replaces the sections it sets as a whole, so a later override can both tighten and relax an earlier
+// one. Source overrides are applied first so they act as per-network defaults and never re-enable a feature a group
+// or user override disabled. Use Network to reject clients from a network regardless of the user.
+//
+// The remoteAddress may be nil if the client address is not known, in which case no source overrides are applied. The
+// returned configuration does not contain any overrides. The second return value lists the overrides that were
+// applied, e.g. `group:operators` or `user:alice`.
+func (c Config) resolve(username string, groups []string, remoteAddress net.IP) (Config, []string) {
+	result := c
+	result.Users = nil
+	result.Groups = nil
+	result.Sources = nil
+	memberOf := map[string]bool{}
+	for _, group := range groups {
+		memberOf[group] = true
+	}
+	var applied []string
+	for _, source := range c.Sources {
+		if network, ok := c.parsed.matchNetwork(source.Networks, remoteAddress); ok {
+			source.OverrideConfig.applyTo(&result)
+			applied = append(applied, "source:"+network)
+		}
+	}
+	for _, group := range c.Groups {
+		if memberOf[group.Group] {
+			group.OverrideConfig.applyTo(&result)
+			applied = append(applied, "group:"+group.Group)
+		}
+	}
+	if override, ok := c.Users[username]; ok {
+		override.applyTo(&result)
+		applied = append(applied, "user:"+username)
+	}
+	return result, applied
+}
@@ -0,0 +1,117 @@
+package security
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverridePrecedence(t *testing.T) {
+	forced := "/bin/menu"
+	unlimited := -1
+	config := Config{
+		MaxSessions: 2,
+		Shell:       ShellConfig{Mode: ExecutionPolicyDisable},
+		TTY:         TTYConfig{Mode: ExecutionPolicyDisable},
+		Groups: []GroupOverrideConfig{
+			{
+				Group: "operators",
+				OverrideConfig: OverrideConfig{
+					Shell:       &ShellConfig{Mode: ExecutionPolicyEnable},
+					TTY:         &TTYConfig{Mode: ExecutionPolicyEnable},
+					MaxSessions: &unlimited,
+				},
+			},
+			{
+				Group: "restricted",
+				OverrideConfig: OverrideConfig{
+					TTY:          &TTYConfig{Mode: ExecutionPolicyDisable},
+					ForceCommand: &forced,
+				},
+			},
+		},
+		Users: map[string]OverrideConfig{
+			"ci": {
+				Shell: &ShellConfig{Mode: ExecutionPolicyDisable},
+			},
+		},
+	}
+	assert.NoError(t, config.Validate())
+
+	regular, applied := config.resolve("alice", nil, nil)
+	assert.Empty(t, applied)
+	assert.Equal(t, ExecutionPolicyDisable, regular.Shell.Mode)
+	assert.Equal(t, 2, regular.MaxSessions)
+	assert.Nil(t, regular.Groups)
+
+	operator, applied := config.resolve("bob", []string{"operators"}, nil)
+	assert.Equal(t, []string{"group:operators"}, applied)
+	assert.Equal(t, ExecutionPolicyEnable, operator.Shell.Mode)
+	assert.Equal(t, ExecutionPolicyEnable, operator.TTY.Mode)
+	assert.Equal(t, -1, operator.MaxSessions)
+
+	restrictedOperator, applied := config.resolve("bob", []string{"restricted", "operators"}, nil)
+	assert.Equal(t, []string{"group:operators", "group:restricted"}, applied)
+	assert.Equal(t, ExecutionPolicyEnable, restrictedOperator.Shell.Mode)
+	assert.Equal(t, ExecutionPolicyDisable, restrictedOperator.TTY.Mode)
+	assert.Equal(t, "/bin/menu", restrictedOperator.ForceCommand)
+
+	ci, applied := config.resolve("ci", []string{"operators"}, nil)
+	assert.Equal(t, []string{"group:operators", "user:ci"}, applied)
+	assert.Equal(t, ExecutionPolicyDisable, ci.Shell.Mode)
+	assert.Equal(t, ExecutionPolicyEnable, ci.TTY.Mode)
+}
+
+func TestSourceOverridePrecedence(t *testing.T) {
+	config := Config{
+		Shell: ShellConfig{Mode: ExecutionPolicyDisable},
+		TTY:   TTYConfig{Mode: ExecutionPolicyDisable},
+		Sources: []SourceOverrideConfig{
+			{
+				Networks: []string{"10.0.0.0/8"},
+				OverrideConfig: OverrideConfig{
+					Shell: &ShellConfig{Mode: ExecutionPolicyEnable},
+					TTY:   &TTYConfig{Mode: ExecutionPolicyEnable},
+				},
+			},
+		},
+		Users: map[string]OverrideConfig{
+			"ci": {Shell: &ShellConfig{Mode: ExecutionPolicyDisable}},
+		},
+	}
+	assert.NoError(t, config.Validate())
+	internal := net.ParseIP("10.1.2.3")
+
+	// The user override disabled the shell, the source override must not enable it again.
+	ci, applied := config.resolve("ci", nil, internal)
+	assert.Equal(t, []string{"source:10.0.0.0/8", "user:ci"}, applied)
+	assert.Equal(t, ExecutionPolicyDisable, ci.Shell.Mode)
+	assert.Equal(t, ExecutionPolicyEnable, ci.TTY.Mode)
+
+	alice, _ := config.resolve("alice", nil, internal)
+	assert.Equal(t, ExecutionPolicyEnable, alice.Shell.Mode)
+
+	external, applied := config.resolve("alice", nil, net.ParseIP("192.0.2.1"))
+	assert.Empty(t, applied)
+	assert.Equal(t, ExecutionPolicyDisable, external.Shell.Mode)
+}
+
+func TestOverrideValidation(t *testing.T) {
+	assert.Error(t, Config{
+		Groups: []GroupOverrideConfig{
+			{Group: "ops"},
+			{Group: "ops"},
+		},
+	}.Validate())
+	assert.Error(t, Config{
+		Groups: []GroupOverrideConfig{
+			{},
+		},
+	}.Validate())
+	assert.Error(t, Config{
+		Users: map[string]OverrideConfig{
+			"alice": {TTY: &TTYConfig{Mode: "invalid"}},
+		},
+	}.Validate())
+}
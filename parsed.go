@@ -0,0 +1,240 @@
+package security
+
+import (
+	"net"
+	"text/template"
+)
+
+// parsedConfig holds the values parsed from the strings of a configuration, e.g. the compiled allow and deny list
+// entries and the templates, keyed by the raw string. New, NewHandler and ConfigHolder build it once when they apply a
+// validated configuration, so requests only look up the parsed values. It is not modified after it has been built and
+// is shared by all copies of the configuration, including the ones resolved for a user.
+//
+// Configurations that were not applied by New, NewHandler or ConfigHolder, e.g. in tests, have no parsed values. Their
+// strings are parsed on demand.
+type parsedConfig struct {
+	matchers        map[string]matcher
+	networks        map[string]*net.IPNet
+	forwardingRules map[string]forwardingRule
+	envTemplates    map[string]*template.Template
+	forceCommands   map[string]*template.Template
+	ttyOpcodes      map[string]byte
+}
+
+// parse returns a copy of the validated configuration with the strings of the base configuration and all overrides
+// parsed. Entries that fail to parse are skipped, Validate rejects these.
+func (c Config) parse() Config {
+	p := &parsedConfig{
+		matchers:        map[string]matcher{},
+		networks:        map[string]*net.IPNet{},
+		forwardingRules: map[string]forwardingRule{},
+		envTemplates:    map[string]*template.Template{},
+		forceCommands:   map[string]*template.Template{},
+		ttyOpcodes:      map[string]byte{},
+	}
+	p.addForceCommand(c.ForceCommand)
+	p.addEnv(c.Env)
+	p.addCommand(c.Command)
+	p.addForceCommand(c.Shell.ForceCommand)
+	p.addSubsystem(c.Subsystem)
+	p.addTTY(c.TTY)
+	p.addPatterns(c.Signal.Allow)
+	p.addPatterns(c.Signal.Deny)
+	p.addForwarding(c.Forwarding)
+	p.addPatterns(c.X11.AllowAuthProtocols)
+	p.addUnsupported(c.Unsupported)
+	p.addNetworks(c.Network.Allow)
+	p.addNetworks(c.Network.Deny)
+	for _, override := range c.Users {
+		p.addOverride(override)
+	}
+	for _, group := range c.Groups {
+		p.addOverride(group.OverrideConfig)
+	}
+	for _, source := range c.Sources {
+		p.addNetworks(source.Networks)
+		p.addOverride(source.OverrideConfig)
+	}
+	c.parsed = p
+	return c
+}
+
+func (p *parsedConfig) addOverride(o OverrideConfig) {
+	if o.ForceCommand != nil {
+		p.addForceCommand(*o.ForceCommand)
+	}
+	if o.Env != nil {
+		p.addEnv(*o.Env)
+	}
+	if o.Command != nil {
+		p.addCommand(*o.Command)
+	}
+	if o.Shell != nil {
+		p.addForceCommand(o.Shell.ForceCommand)
+	}
+	if o.Subsystem != nil {
+		p.addSubsystem(*o.Subsystem)
+	}
+	if o.TTY != nil {
+		p.addTTY(*o.TTY)
+	}
+	if o.Signal != nil {
+		p.addPatterns(o.Signal.Allow)
+		p.addPatterns(o.Signal.Deny)
+	}
+	if o.Forwarding != nil {
+		p.addForwarding(*o.Forwarding)
+	}
+	if o.X11 != nil {
+		p.addPatterns(o.X11.AllowAuthProtocols)
+	}
+	if o.Unsupported != nil {
+		p.addUnsupported(*o.Unsupported)
+	}
+}
+
+func (p *parsedConfig) addEnv(e EnvConfig) {
+	p.addPatterns(e.Allow)
+	p.addPatterns(e.Deny)
+	for _, value := range e.Values {
+		p.addPatterns([]string{value.Name})
+		p.addPatterns(value.Allow)
+	}
+	for name, value := range e.Set {
+		if tpl, err := parseEnvTemplate(name, value); err == nil {
+			p.envTemplates[name+"="+value] = tpl
+		}
+	}
+}
+
+func (p *parsedConfig) addCommand(c CommandConfig) {
+	p.addPatterns(c.Allow)
+	for _, rule := range c.Rules {
+		p.addPatterns(rule.Args)
+		if rule.RemainingArgs != "" {
+			p.addPatterns([]string{rule.RemainingArgs})
+		}
+		p.addForceCommand(rule.ForceCommand)
+	}
+	p.addForceCommand(c.ForceCommand)
+}
+
+func (p *parsedConfig) addSubsystem(s SubsystemConfig) {
+	p.addPatterns(s.Allow)
+	p.addPatterns(s.Deny)
+	for _, forceCommand := range s.ForceCommands {
+		p.addForceCommand(forceCommand)
+	}
+}
+
+func (p *parsedConfig) addTTY(t TTYConfig) {
+	p.addPatterns(t.Allow)
+	p.addPatterns(t.Deny)
+	for _, modeRule := range t.Modes {
+		if opcode, err := parseTTYOpcode(modeRule.Mode); err == nil {
+			p.ttyOpcodes[modeRule.Mode] = opcode
+		}
+	}
+}
+
+func (p *parsedConfig) addForwarding(f ForwardingConfig) {
+	for _, t := range []TCPForwardingConfig{f.Local, f.Remote} {
+		for _, rule := range append(append([]string{}, t.Allow...), t.Deny...) {
+			if parsed, err := parseForwardingRule(rule); err == nil {
+				p.forwardingRules[rule] = parsed
+			}
+		}
+	}
+}
+
+func (p *parsedConfig) addUnsupported(u UnsupportedConfig) {
+	for _, r := range []RequestPolicyConfig{u.ChannelTypes, u.GlobalRequests, u.ChannelRequests} {
+		p.addPatterns(r.Allow)
+		p.addPatterns(r.Deny)
+	}
+}
+
+func (p *parsedConfig) addPatterns(patterns []string) {
+	for _, pattern := range patterns {
+		if m, err := compilePattern(pattern); err == nil {
+			p.matchers[pattern] = m
+		}
+	}
+}
+
+func (p *parsedConfig) addNetworks(networks []string) {
+	for _, network := range networks {
+		if ipNet, err := parseNetwork(network); err == nil {
+			p.networks[network] = ipNet
+		}
+	}
+}
+
+func (p *parsedConfig) addForceCommand(value string) {
+	if value == "" {
+		return
+	}
+	if tpl, err := parseForceCommand(value); err == nil {
+		p.forceCommands[value] = tpl
+	}
+}
+
+// matcher returns the compiled matcher of an allow or deny list entry.
+func (p *parsedConfig) matcher(pattern string) (matcher, error) {
+	if p != nil {
+		if m, ok := p.matchers[pattern]; ok {
+			return m, nil
+		}
+	}
+	return compilePattern(pattern)
+}
+
+// network returns the parsed network of a CIDR block or single IP address.
+func (p *parsedConfig) network(network string) (*net.IPNet, error) {
+	if p != nil {
+		if ipNet, ok := p.networks[network]; ok {
+			return ipNet, nil
+		}
+	}
+	return parseNetwork(network)
+}
+
+// forwardingRule returns the parsed host:port entry of a forwarding allow or deny list.
+func (p *parsedConfig) forwardingRule(rule string) (forwardingRule, error) {
+	if p != nil {
+		if parsed, ok := p.forwardingRules[rule]; ok {
+			return parsed, nil
+		}
+	}
+	return parseForwardingRule(rule)
+}
+
+// envTemplate returns the parsed template of an environment variable in EnvConfig.Set.
+func (p *parsedConfig) envTemplate(name string, value string) (*template.Template, error) {
+	if p != nil {
+		if tpl, ok := p.envTemplates[name+"="+value]; ok {
+			return tpl, nil
+		}
+	}
+	return parseEnvTemplate(name, value)
+}
+
+// forceCommand returns the parsed forced command template.
+func (p *parsedConfig) forceCommand(value string) (*template.Template, error) {
+	if p != nil {
+		if tpl, ok := p.forceCommands[value]; ok {
+			return tpl, nil
+		}
+	}
+	return parseForceCommand(value)
+}
+
+// ttyOpcode returns the opcode of the mode of a TTY mode rule.
+func (p *parsedConfig) ttyOpcode(mode string) (byte, error) {
+	if p != nil {
+		if opcode, ok := p.ttyOpcodes[mode]; ok {
+			return opcode, nil
+		}
+	}
+	return parseTTYOpcode(mode)
+}
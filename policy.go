@@ -0,0 +1,237 @@
+package security
+
+import (
+	"github.com/containerssh/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// This file contains the policy decisions for session channel requests. The check functions have no side effects. They
+// return the decision details and the rejection message if the request does not pass the policy, or nil if it is
+// allowed.
+
+func (c Config) checkEnv(name string) (Decision, log.Message) {
+	mode := c.getPolicy(c.Env.Mode)
+	decision := Decision{RequestType: "env", Subject: name, Mode: mode}
+	if _, ok := c.Env.Set[name]; ok {
+		decision.MatchedRule = "set"
+		return decision, log.UserMessage(
+			EEnvRejected,
+			"Environment variable setting rejected.",
+			"Setting an environment variable is rejected because it is set by the security layer.",
+		).Label("name", name)
+	}
+	switch mode {
+	case ExecutionPolicyDisable:
+		return decision, log.UserMessage(
+			EEnvRejected,
+			"Environment variable setting rejected.",
+			"Setting an environment variable is rejected because it is disabled in the security settings.",
+		).Label("name", name)
+	case ExecutionPolicyFilter:
+		if rule, ok := c.parsed.matchAny(c.Env.Allow, name); ok {
+			decision.MatchedRule = rule
+			return decision, nil
+		}
+		return decision, log.UserMessage(
+			EEnvRejected,
+			"Environment variable setting rejected.",
+			"Setting an environment variable is rejected because it does not match the allow list.",
+		).Label("name", name)
+	case ExecutionPolicyEnable:
+		fallthrough
+	default:
+		rule, ok := c.parsed.matchAny(c.Env.Deny, name)
+		if !ok {
+			return decision, nil
+		}
+		decision.MatchedRule = rule
+		return decision, log.UserMessage(
+			EEnvRejected,
+			"Environment variable setting rejected.",
+			"Setting an environment variable is rejected because it matches the deny list.",
+		).Label("name", name)
+	}
+}
+
+// checkExec evaluates an exec request against the policy. In filter mode it also returns the command rule that allowed
+// the command, or nil if it matched the allow list.
+func (c Config) checkExec(program string) (Decision, *CommandRule, log.Message) {
+	mode := c.getPolicy(c.Command.Mode)
+	decision := Decision{RequestType: "exec", Subject: program, Mode: mode}
+	switch mode {
+	case ExecutionPolicyDisable:
+		return decision, nil, log.UserMessage(
+			EExecRejected,
+			"Command execution disabled.",
+			"Command execution is disabled in the security settings.",
+		)
+	case ExecutionPolicyFilter:
+		matched, rule, reason, ok := c.Command.matchCommandRule(c.parsed, program)
+		if !ok {
+			return decision, nil, log.UserMessage(
+				EExecRejected,
+				"Command execution disabled.",
+				"%s",
+				reason,
+			)
+		}
+		decision.MatchedRule = matched
+		return decision, rule, nil
+	case ExecutionPolicyEnable:
+		fallthrough
+	default:
+		return decision, nil, nil
+	}
+}
+
+func (c Config) checkShell() (Decision, log.Message) {
+	mode := c.getPolicy(c.Shell.Mode)
+	decision := Decision{RequestType: "shell", Mode: mode}
+	switch mode {
+	case ExecutionPolicyDisable:
+		fallthrough
+	case ExecutionPolicyFilter:
+		return decision, log.UserMessage(
+			EShellRejected,
+			"Shell execution disabled.",
+			"Shell execution is disabled in the security settings.",
+		)
+	case ExecutionPolicyEnable:
+		fallthrough
+	default:
+		return decision, nil
+	}
+}
+
+func (c Config) checkSubsystem(subsystem string) (Decision, log.Message) {
+	mode := c.getPolicy(c.Subsystem.Mode)
+	decision := Decision{RequestType: "subsystem", Subject: subsystem, Mode: mode}
+	switch mode {
+	case ExecutionPolicyDisable:
+		return decision, log.UserMessage(
+			ESubsystemRejected,
+			"Subsystem execution disabled.",
+			"Subsystem execution is disabled in the security settings.",
+		)
+	case ExecutionPolicyFilter:
+		rule, ok := c.parsed.matchAny(c.Subsystem.Allow, subsystem)
+		if !ok {
+			return decision, log.UserMessage(
+				ESubsystemRejected,
+				"Subsystem execution disabled.",
+				"The specified subsystem does not match the allowed subsystems list.",
+			)
+		}
+		decision.MatchedRule = rule
+		return decision, nil
+	case ExecutionPolicyEnable:
+		if rule, ok := c.parsed.matchAny(c.Subsystem.Deny, subsystem); ok {
+			decision.MatchedRule = rule
+			return decision, log.UserMessage(
+				ESubsystemRejected,
+				"Subsystem execution disabled.",
+				"The subsystem execution is rejected because the specified subsystem matches the deny list.",
+			)
+		}
+		return decision, nil
+	default:
+		return decision, nil
+	}
+}
+
+func (c Config) checkSignal(signal string) (Decision, log.Message) {
+	mode := c.getPolicy(c.Signal.Mode)
+	decision := Decision{RequestType: "signal", Subject: signal, Mode: mode}
+	switch mode {
+	case ExecutionPolicyDisable:
+		return decision, log.UserMessage(
+			ESignalRejected,
+			"Sending signals is rejected.",
+			"Sending the signal is rejected because signal delivery is disabled.",
+		)
+	case ExecutionPolicyFilter:
+		if rule, ok := c.parsed.matchAny(c.Signal.Allow, signal); ok {
+			decision.MatchedRule = rule
+			return decision, nil
+		}
+		return decision, log.UserMessage(
+			ESignalRejected,
+			"Sending signals is rejected.",
+			"Sending the signal is rejected because the specified signal does not match the allow list.",
+		)
+	case ExecutionPolicyEnable:
+		fallthrough
+	default:
+		rule, ok := c.parsed.matchAny(c.Signal.Deny, signal)
+		if !ok {
+			return decision, nil
+		}
+		decision.MatchedRule = rule
+		return decision, log.UserMessage(
+			ESignalRejected,
+			"Sending signals is rejected.",
+			"Sending the signal is rejected because the specified signal matches the deny list.",
+		)
+	}
+}
+
+func (c Config) checkX11(payload []byte) (Decision, log.Message) {
+	mode := c.getPolicy(c.X11.Mode)
+	decision := Decision{RequestType: "x11-req", Mode: mode}
+	request := x11RequestPayload{}
+	if err := ssh.Unmarshal(payload, &request); err != nil {
+		return decision, log.WrapUser(
+			err,
+			EX11Rejected,
+			"X11 forwarding rejected.",
+			"Failed to decode the X11 forwarding request.",
+		)
+	}
+	decision.Subject = request.AuthProtocol
+	rule, reason := c.X11.check(c.parsed, mode, request)
+	decision.MatchedRule = rule
+	if reason != "" {
+		return decision, log.UserMessage(
+			EX11Rejected,
+			"X11 forwarding rejected.",
+			"%s",
+			reason,
+		).Label("authProtocol", request.AuthProtocol).Label("singleConnection", request.SingleConnection)
+	}
+	return decision, nil
+}
+
+func (c Config) checkAgentForwarding() (Decision, log.Message) {
+	mode := c.getPolicy(c.AgentForwarding.Mode)
+	decision := Decision{RequestType: "auth-agent-req@openssh.com", Mode: mode}
+	switch mode {
+	case ExecutionPolicyDisable:
+		fallthrough
+	case ExecutionPolicyFilter:
+		return decision, log.UserMessage(
+			EAgentForwardingRejected,
+			"Agent forwarding rejected.",
+			"SSH agent forwarding is disabled in the security settings.",
+		)
+	case ExecutionPolicyEnable:
+		fallthrough
+	default:
+		return decision, nil
+	}
+}
+
+func (c Config) checkChannelRequest(requestType string) (Decision, log.Message) {
+	mode := c.getPolicy(c.Unsupported.ChannelRequests.Mode)
+	decision := Decision{RequestType: requestType, Mode: mode}
+	rule, reason := c.Unsupported.ChannelRequests.check(c.parsed, mode, requestType)
+	decision.MatchedRule = rule
+	if reason != "" {
+		return decision, log.UserMessage(
+			EChannelRequestRejected,
+			"Request rejected.",
+			"The channel request is rejected because %s",
+			reason,
+		).Label("requestType", requestType)
+	}
+	return decision, nil
+}
@@ -0,0 +1,34 @@
+package security
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignal(t *testing.T) {
+	backend := &dummyBackend{}
+	session := &sessionHandler{
+		config:  Config{},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	session.config.Shell.Mode = ExecutionPolicyDisable
+	session.config.Signal.Mode = ExecutionPolicyEnable
+	assert.NoError(t, session.OnSignal(1, "TERM"))
+
+	session.config.Shell.Mode = ExecutionPolicyEnable
+	session.config.Signal.Mode = ExecutionPolicyDisable
+	assert.Error(t, session.OnSignal(1, "TERM"))
+
+	session.config.Signal.Mode = ExecutionPolicyFilter
+	session.config.Signal.Allow = []string{"TERM"}
+	assert.NoError(t, session.OnSignal(1, "TERM"))
+	assert.Error(t, session.OnSignal(1, "KILL"))
+}
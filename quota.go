@@ -0,0 +1,372 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/sshserver"
+)
+
+// QuotaAction describes what happens when a data transfer quota is exceeded.
+type QuotaAction string
+
+const (
+	// QuotaActionUnconfigured falls back to QuotaActionClose.
+	QuotaActionUnconfigured QuotaAction = ""
+	// QuotaActionClose closes the session that exceeded the quota.
+	QuotaActionClose QuotaAction = "close"
+	// QuotaActionThrottle limits the bandwidth of the session to ThrottleBytesPerSecond once the quota is exceeded.
+	QuotaActionThrottle QuotaAction = "throttle"
+)
+
+// Validate validates the quota action.
+func (q QuotaAction) Validate() error {
+	switch q {
+	case QuotaActionUnconfigured:
+	case QuotaActionClose:
+	case QuotaActionThrottle:
+	default:
+		return fmt.Errorf("invalid quota action: %s", q)
+	}
+	return nil
+}
+
+// QuotaConfig limits how much data can be transferred through session channels. Upload is the data sent by the client
+// (stdin), download is the data sent to the client (stdout and stderr). Port forwarding and other channel types are
+// not counted. A limit of 0 means unlimited.
+type QuotaConfig struct {
+	// MaxSessionUploadBytes limits the data sent by the client in a single session.
+	MaxSessionUploadBytes int64 `json:"maxSessionUploadBytes" yaml:"maxSessionUploadBytes"`
+	// MaxSessionDownloadBytes limits the data sent to the client in a single session.
+	MaxSessionDownloadBytes int64 `json:"maxSessionDownloadBytes" yaml:"maxSessionDownloadBytes"`
+	// MaxConnectionUploadBytes limits the data sent by the client across all sessions of a connection.
+	MaxConnectionUploadBytes int64 `json:"maxConnectionUploadBytes" yaml:"maxConnectionUploadBytes"`
+	// MaxConnectionDownloadBytes limits the data sent to the client across all sessions of a connection.
+	MaxConnectionDownloadBytes int64 `json:"maxConnectionDownloadBytes" yaml:"maxConnectionDownloadBytes"`
+	// Action configures what happens when a quota is exceeded. Defaults to QuotaActionClose.
+	Action QuotaAction `json:"action" yaml:"action"`
+	// ThrottleBytesPerSecond is the bandwidth in each direction of a session that exceeded a quota when Action is
+	// QuotaActionThrottle.
+	ThrottleBytesPerSecond int64 `json:"throttleBytesPerSecond" yaml:"throttleBytesPerSecond"`
+}
+
+// Validate validates the quota configuration.
+func (q QuotaConfig) Validate() error {
+	if q.MaxSessionUploadBytes < 0 {
+		return fmt.Errorf("invalid maxSessionUploadBytes setting: %d", q.MaxSessionUploadBytes)
+	}
+	if q.MaxSessionDownloadBytes < 0 {
+		return fmt.Errorf("invalid maxSessionDownloadBytes setting: %d", q.MaxSessionDownloadBytes)
+	}
+	if q.MaxConnectionUploadBytes < 0 {
+		return fmt.Errorf("invalid maxConnectionUploadBytes setting: %d", q.MaxConnectionUploadBytes)
+	}
+	if q.MaxConnectionDownloadBytes < 0 {
+		return fmt.Errorf("invalid maxConnectionDownloadBytes setting: %d", q.MaxConnectionDownloadBytes)
+	}
+	if err := q.Action.Validate(); err != nil {
+		return fmt.Errorf("invalid action (%w)", err)
+	}
+	if q.ThrottleBytesPerSecond < 0 {
+		return fmt.Errorf("invalid throttleBytesPerSecond setting: %d", q.ThrottleBytesPerSecond)
+	}
+	if q.Action == QuotaActionThrottle && q.ThrottleBytesPerSecond == 0 {
+		return fmt.Errorf("throttleBytesPerSecond must be set when the action is %s", QuotaActionThrottle)
+	}
+	return nil
+}
+
+// enabled returns true if any quota is configured.
+func (q QuotaConfig) enabled() bool {
+	return q.MaxSessionUploadBytes > 0 ||
+		q.MaxSessionDownloadBytes > 0 ||
+		q.MaxConnectionUploadBytes > 0 ||
+		q.MaxConnectionDownloadBytes > 0
+}
+
+// connectionTransfer counts the data transferred across all sessions of a connection. The lock also guards the
+// counters of the sessionQuota instances of the connection.
+type connectionTransfer struct {
+	lock     *sync.Mutex
+	upload   int64
+	download int64
+}
+
+func newConnectionTransfer() *connectionTransfer {
+	return &connectionTransfer{
+		lock: &sync.Mutex{},
+	}
+}
+
+// sessionQuota enforces the QuotaConfig on a session. Data is counted by the quotaSessionChannel wrapping the channel
+// passed to the backend.
+type sessionQuota struct {
+	config     QuotaConfig
+	clock      Clock
+	session    *sessionHandler
+	connection *connectionTransfer
+	upload     int64
+	download   int64
+	// exceededOnce makes sure the session is only closed or throttled once.
+	exceededOnce sync.Once
+	exceeded     log.Message
+	// uploadThrottle and downloadThrottle track the data transferred since the session was throttled.
+	uploadThrottle   quotaThrottle
+	downloadThrottle quotaThrottle
+	// done is closed when the session is closed to stop waiting for the throttle.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSessionQuota(
+	config QuotaConfig,
+	clock Clock,
+	session *sessionHandler,
+	connection *connectionTransfer,
+) *sessionQuota {
+	return &sessionQuota{
+		config:     config,
+		clock:      clock,
+		session:    session,
+		connection: connection,
+		done:       make(chan struct{}),
+	}
+}
+
+// quotaThrottle tracks the data transferred in one direction since the session was throttled.
+type quotaThrottle struct {
+	start time.Time
+	bytes int64
+}
+
+// throttleState returns the throttle of the direction. Must be called with the connection lock held.
+func (q *sessionQuota) throttleState(upload bool) *quotaThrottle {
+	if upload {
+		return &q.uploadThrottle
+	}
+	return &q.downloadThrottle
+}
+
+// stop releases goroutines waiting for the throttle, for example because the session was closed.
+func (q *sessionQuota) stop() {
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+}
+
+// remaining returns how many bytes may still be transferred in the direction before a quota is exceeded, or -1 if
+// there is no limit. Must be called with the connection lock held.
+func (q *sessionQuota) remaining(upload bool) int64 {
+	sessionLimit, sessionUsed := q.config.MaxSessionDownloadBytes, q.download
+	connectionLimit, connectionUsed := q.config.MaxConnectionDownloadBytes, q.connection.download
+	if upload {
+		sessionLimit, sessionUsed = q.config.MaxSessionUploadBytes, q.upload
+		connectionLimit, connectionUsed = q.config.MaxConnectionUploadBytes, q.connection.upload
+	}
+	remaining := int64(-1)
+	if sessionLimit > 0 {
+		remaining = max64(sessionLimit-sessionUsed, 0)
+	}
+	if connectionLimit > 0 && (remaining < 0 || connectionLimit-connectionUsed < remaining) {
+		remaining = max64(connectionLimit-connectionUsed, 0)
+	}
+	return remaining
+}
+
+// account counts n bytes in the direction. It returns how many of them may be transferred and the quota error if the
+// quota is exceeded and the action is QuotaActionClose. In throttle mode it blocks until the throttled bandwidth
+// permits the transfer.
+func (q *sessionQuota) account(upload bool, n int) (int, error) {
+	if n <= 0 {
+		return n, nil
+	}
+	q.connection.lock.Lock()
+	if q.exceeded != nil && q.config.Action != QuotaActionThrottle {
+		q.connection.lock.Unlock()
+		return 0, q.exceeded
+	}
+	remaining := q.remaining(upload)
+	allowed := int64(n)
+	over := int64(0)
+	if remaining >= 0 && allowed > remaining {
+		over = allowed - remaining
+		if q.config.Action != QuotaActionThrottle {
+			allowed = remaining
+		}
+	}
+	q.add(upload, allowed)
+	var wait time.Duration
+	throttle := q.config.Action == QuotaActionThrottle && (over > 0 || q.throttleState(upload).bytes > 0)
+	if throttle {
+		wait = q.throttle(upload, over)
+	}
+	q.connection.lock.Unlock()
+
+	if over > 0 {
+		q.exceed(upload)
+	}
+	if throttle {
+		q.sleep(wait)
+		return n, nil
+	}
+	if over > 0 {
+		q.connection.lock.Lock()
+		defer q.connection.lock.Unlock()
+		return int(allowed), q.exceeded
+	}
+	return n, nil
+}
+
+// add counts the transferred data. Must be called with the connection lock held.
+func (q *sessionQuota) add(upload bool, n int64) {
+	if upload {
+		q.upload += n
+		q.connection.upload += n
+	} else {
+		q.download += n
+		q.connection.download += n
+	}
+}
+
+// throttle counts the throttled data and returns how long the transfer must wait. Data over the quota is counted
+// from the first time the quota was exceeded. Must be called with the connection lock held.
+func (q *sessionQuota) throttle(upload bool, over int64) time.Duration {
+	now := q.clock.Now()
+	state := q.throttleState(upload)
+	if state.bytes == 0 {
+		state.start = now
+	}
+	state.bytes += over
+	seconds := float64(state.bytes) / float64(q.config.ThrottleBytesPerSecond)
+	due := state.start.Add(time.Duration(seconds * float64(time.Second)))
+	return due.Sub(now)
+}
+
+func (q *sessionQuota) sleep(wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	elapsed := make(chan struct{})
+	timer := q.clock.AfterFunc(wait, func() {
+		close(elapsed)
+	})
+	select {
+	case <-elapsed:
+	case <-q.done:
+		timer.Stop()
+	}
+}
+
+// exceed notifies the user and closes or throttles the session the first time a quota is exceeded.
+func (q *sessionQuota) exceed(upload bool) {
+	q.exceededOnce.Do(func() {
+		direction := "download"
+		if upload {
+			direction = "upload"
+		}
+		session := q.session
+		if q.config.Action == QuotaActionThrottle {
+			message := log.UserMessage(
+				MQuotaThrottled,
+				"This session exceeded its data transfer quota and is now throttled.",
+				"Throttling the session to %d bytes per second because it exceeded its %s quota.",
+				q.config.ThrottleBytesPerSecond,
+				direction,
+			)
+			session.sshConnection.addLabels(message)
+			session.logger.Info(message.Label("channelId", session.channelID))
+			q.connection.lock.Lock()
+			q.exceeded = message
+			q.connection.lock.Unlock()
+			session.writeStderr(message.UserMessage())
+			return
+		}
+		message := log.UserMessage(
+			EQuotaExceeded,
+			"Your session has been closed because it exceeded its data transfer quota.",
+			"Closing the session because it exceeded its %s quota.",
+			direction,
+		)
+		q.connection.lock.Lock()
+		q.exceeded = message
+		q.connection.lock.Unlock()
+		// The session is closed in the background because the backend may wait for its own I/O on shutdown.
+		go func() {
+			session.writeStderr(message.UserMessage())
+			shutdownContext, cancel := context.WithTimeout(context.Background(), session.getConfig().gracePeriod())
+			defer cancel()
+			session.terminate(shutdownContext, message, nil)
+		}()
+	})
+}
+
+func max64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// quotaSessionChannel counts the input and output of a session against the session quota.
+type quotaSessionChannel struct {
+	sshserver.SessionChannel
+	quota *sessionQuota
+}
+
+func (c *quotaSessionChannel) Stdin() io.Reader {
+	return &quotaReader{
+		reader: c.SessionChannel.Stdin(),
+		quota:  c.quota,
+	}
+}
+
+func (c *quotaSessionChannel) Stdout() io.Writer {
+	return &quotaWriter{
+		writer: c.SessionChannel.Stdout(),
+		quota:  c.quota,
+	}
+}
+
+func (c *quotaSessionChannel) Stderr() io.Writer {
+	return &quotaWriter{
+		writer: c.SessionChannel.Stderr(),
+		quota:  c.quota,
+	}
+}
+
+type quotaReader struct {
+	reader io.Reader
+	quota  *sessionQuota
+}
+
+// Read reads from the client and drops data that exceeds the quota.
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.reader.Read(p)
+	allowed, quotaErr := q.quota.account(true, n)
+	if quotaErr != nil {
+		return allowed, quotaErr
+	}
+	return n, err
+}
+
+type quotaWriter struct {
+	writer io.Writer
+	quota  *sessionQuota
+}
+
+// Write writes the part of the data to the client that fits within the quota.
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	allowed, quotaErr := q.quota.account(false, len(p))
+	if allowed == 0 && quotaErr != nil {
+		return 0, quotaErr
+	}
+	n, err := q.writer.Write(p[:allowed])
+	if err != nil {
+		return n, err
+	}
+	return n, quotaErr
+}
@@ -0,0 +1,122 @@
+package security
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaCloseOnDownload(t *testing.T) {
+	connection, backend := newTimeoutTestConnection(t, newFakeClock(), Config{
+		MaxSessions: -1,
+		Quota: QuotaConfig{
+			MaxSessionDownloadBytes: 8,
+		},
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+	assert.NoError(t, session.OnShell(0))
+
+	n, err := backend.channel.Stdout().Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	n, err = backend.channel.Stdout().Write([]byte("world!"))
+	assert.Error(t, err)
+	assert.Equal(t, 3, n)
+
+	assert.Eventually(t, channel.isClosed, time.Second, time.Millisecond)
+	assert.Equal(
+		t,
+		"Your session has been closed because it exceeded its data transfer quota.\r\n",
+		channel.stderrString(),
+	)
+
+	n, err = backend.channel.Stderr().Write([]byte("x"))
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestQuotaConnectionUpload(t *testing.T) {
+	connection, backend := newTimeoutTestConnection(t, newFakeClock(), Config{
+		MaxSessions: -1,
+		Quota: QuotaConfig{
+			MaxConnectionUploadBytes: 7,
+		},
+	})
+	buf := make([]byte, 16)
+
+	channel1 := newTimeoutTestChannel()
+	session1, rejection := connection.OnSessionChannel(0, []byte{}, channel1)
+	assert.Nil(t, rejection)
+	channel1.handler = session1
+	assert.NoError(t, session1.OnShell(0))
+	n, err := backend.channel.Stdin().Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	channel2 := newTimeoutTestChannel()
+	session2, rejection := connection.OnSessionChannel(1, []byte{}, channel2)
+	assert.Nil(t, rejection)
+	channel2.handler = session2
+	assert.NoError(t, session2.OnShell(0))
+	n, err = backend.channel.Stdin().Read(buf)
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+
+	assert.Eventually(t, channel2.isClosed, time.Second, time.Millisecond)
+	assert.False(t, channel1.isClosed())
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	clock := newFakeClock()
+	connection, backend := newTimeoutTestConnection(t, clock, Config{
+		MaxSessions: -1,
+		Quota: QuotaConfig{
+			MaxSessionDownloadBytes: 5,
+			Action:                  QuotaActionThrottle,
+			ThrottleBytesPerSecond:  10,
+		},
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+	assert.NoError(t, session.OnShell(0))
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		n, err := backend.channel.Stdout().Write([]byte(strings.Repeat("x", 15)))
+		done <- result{n, err}
+	}()
+	assert.Eventually(t, func() bool {
+		return clock.pending() == 1
+	}, time.Second, time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("the write was not throttled")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	r := <-done
+	assert.NoError(t, r.err)
+	assert.Equal(t, 15, r.n)
+	assert.False(t, channel.isClosed())
+	assert.Equal(t, "This session exceeded its data transfer quota and is now throttled.\r\n", channel.stderrString())
+}
+
+func TestQuotaValidation(t *testing.T) {
+	assert.Error(t, QuotaConfig{Action: QuotaActionThrottle}.Validate())
+	assert.Error(t, QuotaConfig{MaxSessionUploadBytes: -1}.Validate())
+	assert.Error(t, QuotaConfig{Action: "drop"}.Validate())
+	assert.NoError(t, QuotaConfig{Action: QuotaActionThrottle, ThrottleBytesPerSecond: 1024}.Validate())
+}
@@ -0,0 +1,239 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// RateLimitAction describes what happens when a request exceeds a rate limit.
+type RateLimitAction string
+
+const (
+	// RateLimitActionUnconfigured falls back to RateLimitActionReject.
+	RateLimitActionUnconfigured RateLimitAction = ""
+	// RateLimitActionReject rejects the request that exceeded the rate limit.
+	RateLimitActionReject RateLimitAction = "reject"
+	// RateLimitActionClose rejects the request and closes the session channel it was sent on.
+	RateLimitActionClose RateLimitAction = "close"
+	// RateLimitActionDisconnect rejects the request, closes all sessions of the connection, and rejects all further
+	// channels and requests on the connection.
+	RateLimitActionDisconnect RateLimitAction = "disconnect"
+)
+
+// Validate validates the rate limit action.
+func (r RateLimitAction) Validate() error {
+	switch r {
+	case RateLimitActionUnconfigured:
+	case RateLimitActionReject:
+	case RateLimitActionClose:
+	case RateLimitActionDisconnect:
+	default:
+		return fmt.Errorf("invalid rate limit action: %s", r)
+	}
+	return nil
+}
+
+// RateLimit is a token bucket limit for a request type.
+type RateLimit struct {
+	// Rate is the number of requests per second allowed on average.
+	Rate float64 `json:"rate" yaml:"rate"`
+	// Burst is the number of requests that can be sent at once. Defaults to 1.
+	Burst int `json:"burst" yaml:"burst"`
+}
+
+// Validate validates the rate limit.
+func (r RateLimit) Validate() error {
+	if r.Rate <= 0 {
+		return fmt.Errorf("invalid rate: %f", r.Rate)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("invalid burst: %d", r.Burst)
+	}
+	return nil
+}
+
+func (r RateLimit) burst() float64 {
+	if r.Burst == 0 {
+		return 1
+	}
+	return float64(r.Burst)
+}
+
+// RateLimitConfig limits how often clients can send session channel requests, e.g. `env`, `window-change` or
+// `signal`. The limits are keyed by request type, the `*` key applies to all request types without a dedicated
+// entry. Each entry has its own token bucket, so all request types without a dedicated entry share the bucket of `*`.
+type RateLimitConfig struct {
+	// Session contains the limits for each session channel.
+	Session map[string]RateLimit `json:"session" yaml:"session"`
+	// Connection contains the limits shared by all session channels of a connection.
+	Connection map[string]RateLimit `json:"connection" yaml:"connection"`
+	// Action configures what happens when a request exceeds a limit. Defaults to RateLimitActionReject.
+	//
+	// The sshserver library does not give the security layer access to the network connection, therefore
+	// RateLimitActionDisconnect closes all sessions and rejects everything else on the connection instead of closing it.
+	Action RateLimitAction `json:"action" yaml:"action"`
+}
+
+// Validate validates the rate limit configuration.
+func (r RateLimitConfig) Validate() error {
+	for requestType, limit := range r.Session {
+		if requestType == "" {
+			return fmt.Errorf("empty request type in session limits")
+		}
+		if err := limit.Validate(); err != nil {
+			return fmt.Errorf("invalid session limit for %s (%w)", requestType, err)
+		}
+	}
+	for requestType, limit := range r.Connection {
+		if requestType == "" {
+			return fmt.Errorf("empty request type in connection limits")
+		}
+		if err := limit.Validate(); err != nil {
+			return fmt.Errorf("invalid connection limit for %s (%w)", requestType, err)
+		}
+	}
+	if err := r.Action.Validate(); err != nil {
+		return fmt.Errorf("invalid action (%w)", err)
+	}
+	return nil
+}
+
+// lookupRateLimit returns the limit for the request type and the key it is configured under.
+func lookupRateLimit(limits map[string]RateLimit, requestType string) (string, RateLimit, bool) {
+	if limit, ok := limits[requestType]; ok {
+		return requestType, limit, true
+	}
+	if limit, ok := limits["*"]; ok {
+		return "*", limit, true
+	}
+	return "", RateLimit{}, false
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps the token buckets of a session or a connection. The limits are passed on every call so a reloaded
+// configuration takes effect without losing the state of the buckets.
+type rateLimiter struct {
+	lock    *sync.Mutex
+	clock   Clock
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(clock Clock) *rateLimiter {
+	return &rateLimiter{
+		lock:    &sync.Mutex{},
+		clock:   clock,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// bucket returns the refilled bucket of the limit matching the request type and the key the limit is configured
+// under. Request types matching the `*` limit share a single bucket. It returns nil if the request type is not
+// limited. Must be called with the lock held.
+func (r *rateLimiter) bucket(limits map[string]RateLimit, requestType string) (*tokenBucket, string) {
+	key, limit, ok := lookupRateLimit(limits, requestType)
+	if !ok {
+		return nil, ""
+	}
+	now := r.clock.Now()
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: limit.burst(), last: now}
+		r.buckets[key] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * limit.Rate
+	if bucket.tokens > limit.burst() {
+		bucket.tokens = limit.burst()
+	}
+	bucket.last = now
+	return bucket, key
+}
+
+// allowRequest takes a token from the session and the connection bucket of the request type. Tokens are only taken
+// if both buckets allow the request. Otherwise it returns false with the scope and the key of the exceeded limit.
+func (s *sessionHandler) allowRequest(config RateLimitConfig, requestType string) (string, string, bool) {
+	if len(config.Session) == 0 && len(config.Connection) == 0 {
+		return "", "", true
+	}
+	session := s.rateLimiter
+	connection := s.sshConnection.getRateLimiter()
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+
+	sessionBucket, key := session.bucket(config.Session, requestType)
+	if sessionBucket != nil && sessionBucket.tokens < 1 {
+		return "session", key, false
+	}
+	connectionBucket, key := connection.bucket(config.Connection, requestType)
+	if connectionBucket != nil && connectionBucket.tokens < 1 {
+		return "connection", key, false
+	}
+	if sessionBucket != nil {
+		sessionBucket.tokens--
+	}
+	if connectionBucket != nil {
+		connectionBucket.tokens--
+	}
+	return "", "", true
+}
+
+// checkRateLimit evaluates the session and connection rate limits for a request and carries out the configured action
+// if a limit is exceeded. It returns the rejection if the request must be rejected.
+func (s *sessionHandler) checkRateLimit(requestType string) error {
+	config := s.getConfig()
+	scope, rule, ok := s.allowRequest(config.RateLimits, requestType)
+	if ok {
+		return nil
+	}
+	rejection := log.UserMessage(
+		ERequestRateLimited,
+		"Too many requests.",
+		"The %s request is rejected because it exceeds the %s rate limit.",
+		requestType,
+		scope,
+	).Label("requestType", requestType)
+	decision := Decision{RequestType: requestType, MatchedRule: scope + ":" + rule}
+	if err := s.decide(config, EnforcementModeUnconfigured, decision, rejection); err == nil {
+		return nil
+	}
+	gracePeriod := config.gracePeriod()
+	switch config.RateLimits.Action {
+	case RateLimitActionClose:
+		// The session is closed in the background because the backend may wait for the request on shutdown.
+		go func() {
+			shutdownContext, cancel := context.WithTimeout(context.Background(), gracePeriod)
+			defer cancel()
+			s.terminate(shutdownContext, log.UserMessage(
+				ERequestRateLimited,
+				"Your session has been closed because it sent too many requests.",
+				"Closing the session because the %s request exceeds the %s rate limit.",
+				requestType,
+				scope,
+			), nil)
+		}()
+	case RateLimitActionDisconnect:
+		go func() {
+			shutdownContext, cancel := context.WithTimeout(context.Background(), gracePeriod)
+			defer cancel()
+			s.sshConnection.disconnect(shutdownContext, func() log.Message {
+				return log.UserMessage(
+					ERequestRateLimited,
+					"Your connection has been closed because it sent too many requests.",
+					"Closing all sessions of the connection because the %s request exceeds the %s rate limit.",
+					requestType,
+					scope,
+				)
+			})
+		}()
+	}
+	return rejection
+}
@@ -0,0 +1,157 @@
+package security
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitReject(t *testing.T) {
+	clock := newFakeClock()
+	connection, _ := newTimeoutTestConnection(t, clock, Config{
+		MaxSessions: -1,
+		RateLimits: RateLimitConfig{
+			Session: map[string]RateLimit{
+				"env": {Rate: 1, Burst: 2},
+			},
+		},
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+
+	assert.NoError(t, session.OnEnvRequest(0, "LANG", "C"))
+	assert.NoError(t, session.OnEnvRequest(1, "LANG", "C"))
+	err := session.OnEnvRequest(2, "LANG", "C")
+	assert.Error(t, err)
+	assert.Equal(t, ERequestRateLimited, err.(log.Message).Code())
+	// Other request types are not limited.
+	assert.NoError(t, session.OnPtyRequest(3, "xterm", 80, 25, 800, 600, []byte{}))
+
+	clock.Advance(time.Second)
+	assert.NoError(t, session.OnEnvRequest(4, "LANG", "C"))
+	assert.False(t, channel.isClosed())
+}
+
+func TestRateLimitWildcardSharesBucket(t *testing.T) {
+	connection, _ := newTimeoutTestConnection(t, newFakeClock(), Config{
+		MaxSessions: -1,
+		RateLimits: RateLimitConfig{
+			Session: map[string]RateLimit{
+				"*": {Rate: 1, Burst: 5},
+			},
+		},
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		session.OnUnsupportedChannelRequest(uint64(i), fmt.Sprintf("request-%d@example.com", i), []byte{})
+	}
+	for i := 0; i < 100; i++ {
+		if session.OnEnvRequest(uint64(100+i), fmt.Sprintf("VAR%d", i), "value") == nil {
+			allowed++
+		}
+	}
+	assert.Equal(t, 0, allowed)
+	assert.Equal(t, 1, len(session.(*sessionHandler).rateLimiter.buckets))
+}
+
+func TestRateLimitConnectionRejectionKeepsSessionTokens(t *testing.T) {
+	connection, _ := newTimeoutTestConnection(t, newFakeClock(), Config{
+		MaxSessions: -1,
+		RateLimits: RateLimitConfig{
+			Session: map[string]RateLimit{
+				"env": {Rate: 1, Burst: 2},
+			},
+			Connection: map[string]RateLimit{
+				"env": {Rate: 1, Burst: 2},
+			},
+		},
+	})
+	channel1 := newTimeoutTestChannel()
+	session1, rejection := connection.OnSessionChannel(0, []byte{}, channel1)
+	assert.Nil(t, rejection)
+	channel1.handler = session1
+	channel2 := newTimeoutTestChannel()
+	session2, rejection := connection.OnSessionChannel(1, []byte{}, channel2)
+	assert.Nil(t, rejection)
+	channel2.handler = session2
+
+	assert.NoError(t, session1.OnEnvRequest(0, "LANG", "C"))
+	assert.NoError(t, session1.OnEnvRequest(1, "LANG", "C"))
+	assert.Error(t, session2.OnEnvRequest(0, "LANG", "C"))
+	assert.Error(t, session2.OnEnvRequest(1, "LANG", "C"))
+	assert.Equal(t, float64(2), session2.(*sessionHandler).rateLimiter.buckets["env"].tokens)
+}
+
+func TestRateLimitClose(t *testing.T) {
+	connection, _ := newTimeoutTestConnection(t, newFakeClock(), Config{
+		MaxSessions: -1,
+		RateLimits: RateLimitConfig{
+			Session: map[string]RateLimit{
+				"*": {Rate: 1},
+			},
+			Action: RateLimitActionClose,
+		},
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+
+	assert.NoError(t, session.OnEnvRequest(0, "LANG", "C"))
+	assert.Error(t, session.OnEnvRequest(1, "LANG", "C"))
+	assert.Eventually(t, channel.isClosed, time.Second, time.Millisecond)
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	connection, _ := newTimeoutTestConnection(t, newFakeClock(), Config{
+		MaxSessions: -1,
+		RateLimits: RateLimitConfig{
+			Connection: map[string]RateLimit{
+				"signal": {Rate: 0.1},
+			},
+			Action: RateLimitActionDisconnect,
+		},
+	})
+	channel1 := newTimeoutTestChannel()
+	session1, rejection := connection.OnSessionChannel(0, []byte{}, channel1)
+	assert.Nil(t, rejection)
+	channel1.handler = session1
+	channel2 := newTimeoutTestChannel()
+	session2, rejection := connection.OnSessionChannel(1, []byte{}, channel2)
+	assert.Nil(t, rejection)
+	channel2.handler = session2
+
+	assert.NoError(t, session1.OnSignal(0, "TERM"))
+	assert.NoError(t, session2.OnShell(0))
+	err := session2.OnSignal(1, "TERM")
+	assert.Error(t, err)
+	assert.Equal(t, ERequestRateLimited, err.(log.Message).Code())
+
+	assert.Eventually(t, func() bool {
+		return channel1.isClosed() && channel2.isClosed()
+	}, time.Second, time.Millisecond)
+
+	_, rejection = connection.OnSessionChannel(2, []byte{}, newTimeoutTestChannel())
+	assert.NotNil(t, rejection)
+	assert.Equal(t, EConnectionDisconnected, rejection.(log.Message).Code())
+}
+
+func TestRateLimitValidation(t *testing.T) {
+	assert.Error(t, RateLimitConfig{Session: map[string]RateLimit{"env": {}}}.Validate())
+	assert.Error(t, RateLimitConfig{Connection: map[string]RateLimit{"env": {Rate: 1, Burst: -1}}}.Validate())
+	assert.Error(t, RateLimitConfig{Action: "drop"}.Validate())
+	assert.NoError(t, RateLimitConfig{
+		Session: map[string]RateLimit{"*": {Rate: 10, Burst: 20}},
+		Action:  RateLimitActionDisconnect,
+	}.Validate())
+}
@@ -0,0 +1,30 @@
+package security
+
+import (
+	"github.com/containerssh/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// channelRejection is a log message carrying the SSH rejection reason for a channel or request.
+type channelRejection struct {
+	log.Message
+	reason ssh.RejectionReason
+}
+
+func newChannelRejection(reason ssh.RejectionReason, message log.Message) *channelRejection {
+	return &channelRejection{
+		Message: message,
+		reason:  reason,
+	}
+}
+
+// Label adds a label to the message.
+func (c *channelRejection) Label(name log.LabelName, value log.LabelValue) log.Message {
+	c.Message.Label(name, value)
+	return c
+}
+
+// Reason returns the SSH rejection reason.
+func (c *channelRejection) Reason() ssh.RejectionReason {
+	return c.reason
+}
@@ -0,0 +1,110 @@
+package security
+
+import (
+	"fmt"
+
+	"github.com/containerssh/log"
+)
+
+// SequencingMode drives how strictly the order of requests on a session channel is enforced.
+type SequencingMode string
+
+const (
+	// SequencingModeUnconfigured falls back to SequencingModeOff.
+	SequencingModeUnconfigured SequencingMode = ""
+	// SequencingModeOff checks each request in isolation.
+	SequencingModeOff SequencingMode = "off"
+	// SequencingModeLenient allows only one program (exec, shell or subsystem) request per session channel.
+	SequencingModeLenient SequencingMode = "lenient"
+	// SequencingModeStrict additionally only allows env, pty-req, x11-req and auth-agent-req@openssh.com requests
+	// before the program is started, and signal and window-change requests after the program is started.
+	SequencingModeStrict SequencingMode = "strict"
+)
+
+// Validate validates the sequencing mode.
+func (s SequencingMode) Validate() error {
+	switch s {
+	case SequencingModeUnconfigured:
+	case SequencingModeOff:
+	case SequencingModeLenient:
+	case SequencingModeStrict:
+	default:
+		return fmt.Errorf("invalid sequencing mode: %s", s)
+	}
+	return nil
+}
+
+// requestPhase describes when a session channel request may be sent.
+type requestPhase int
+
+const (
+	// requestPhaseAny requests may be sent at any time.
+	requestPhaseAny requestPhase = iota
+	// requestPhaseSetup requests prepare the program and may only be sent before it is started.
+	requestPhaseSetup
+	// requestPhaseProgram requests start the program and may only be sent once.
+	requestPhaseProgram
+	// requestPhaseRunning requests interact with the program and may only be sent after it is started.
+	requestPhaseRunning
+)
+
+func getRequestPhase(requestType string) requestPhase {
+	switch requestType {
+	case "env", "pty-req", "x11-req", "auth-agent-req@openssh.com":
+		return requestPhaseSetup
+	case "exec", "shell", "subsystem":
+		return requestPhaseProgram
+	case "signal", "window-change":
+		return requestPhaseRunning
+	default:
+		return requestPhaseAny
+	}
+}
+
+// checkSequence checks the request against the state of the session according to the sequencing mode.
+func (s *sessionHandler) checkSequence(config Config, requestType string) error {
+	mode := config.Sequencing
+	if mode == SequencingModeUnconfigured || mode == SequencingModeOff {
+		return nil
+	}
+	s.stateLock.Lock()
+	started := s.requestType != ""
+	s.stateLock.Unlock()
+
+	var rejection log.Message
+	switch getRequestPhase(requestType) {
+	case requestPhaseProgram:
+		if started {
+			rejection = log.UserMessage(
+				ERequestOutOfOrder,
+				"Only one program can be started per session.",
+				"The %s request is rejected because a program has already been started on this session.",
+				requestType,
+			)
+		}
+	case requestPhaseSetup:
+		if started && mode == SequencingModeStrict {
+			rejection = log.UserMessage(
+				ERequestOutOfOrder,
+				"Request rejected because the program has already been started.",
+				"The %s request is rejected because the program has already been started.",
+				requestType,
+			)
+		}
+	case requestPhaseRunning:
+		if !started && mode == SequencingModeStrict {
+			rejection = log.UserMessage(
+				ERequestOutOfOrder,
+				"Request rejected because no program has been started yet.",
+				"The %s request is rejected because no program has been started yet.",
+				requestType,
+			)
+		}
+	}
+	if rejection == nil {
+		return nil
+	}
+	rejection.Label("requestType", requestType)
+	decision := Decision{RequestType: requestType, MatchedRule: "sequencing:" + string(mode)}
+	return s.decide(config, EnforcementModeUnconfigured, decision, rejection)
+}
@@ -0,0 +1,81 @@
+package security
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequencingOff(t *testing.T) {
+	session := openSequencingTestSession(t, Config{MaxSessions: -1})
+	assert.NoError(t, session.OnExecRequest(0, "ls"))
+	assert.NoError(t, session.OnEnvRequest(1, "LANG", "C"))
+	assert.NoError(t, session.OnShell(2))
+}
+
+func TestSequencingLenient(t *testing.T) {
+	session := openSequencingTestSession(t, Config{MaxSessions: -1, Sequencing: SequencingModeLenient})
+	assert.NoError(t, session.OnSignal(0, "TERM"))
+	assert.NoError(t, session.OnExecRequest(1, "ls"))
+	assert.NoError(t, session.OnEnvRequest(2, "LANG", "C"))
+	assertOutOfOrder(t, session.OnShell(3))
+	assertOutOfOrder(t, session.OnSubsystem(4, "sftp"))
+}
+
+func TestSequencingStrict(t *testing.T) {
+	session := openSequencingTestSession(t, Config{MaxSessions: -1, Sequencing: SequencingModeStrict})
+	assertOutOfOrder(t, session.OnSignal(0, "TERM"))
+	assertOutOfOrder(t, session.OnWindow(1, 80, 25, 800, 600))
+	assert.NoError(t, session.OnEnvRequest(2, "LANG", "C"))
+	assert.NoError(t, session.OnPtyRequest(3, "xterm", 80, 25, 800, 600, []byte{}))
+	assert.NoError(t, session.OnShell(4))
+	assertOutOfOrder(t, session.OnEnvRequest(5, "LANG", "C"))
+	assertOutOfOrder(t, session.OnPtyRequest(6, "xterm", 80, 25, 800, 600, []byte{}))
+	assertOutOfOrder(t, session.OnExecRequest(7, "ls"))
+	assert.NoError(t, session.OnWindow(8, 100, 30, 1000, 700))
+	assert.NoError(t, session.OnSignal(9, "TERM"))
+}
+
+func TestSequencingFailedProgramStart(t *testing.T) {
+	session := openSequencingTestSession(t, Config{
+		MaxSessions: -1,
+		Sequencing:  SequencingModeStrict,
+	})
+	backend := session.backend.(*shutdownRecordingBackend)
+	backend.execErr = fmt.Errorf("program not found")
+	assert.Error(t, session.OnExecRequest(0, "ls"))
+	assert.Equal(t, "", session.info().RequestType)
+
+	backend.execErr = nil
+	assert.NoError(t, session.OnEnvRequest(1, "LANG", "C"))
+	assert.NoError(t, session.OnExecRequest(2, "ls"))
+	assert.Equal(t, "exec", session.info().RequestType)
+}
+
+func TestSequencingAudit(t *testing.T) {
+	session := openSequencingTestSession(t, Config{
+		MaxSessions: -1,
+		Sequencing:  SequencingModeStrict,
+		Enforcement: EnforcementModeAudit,
+	})
+	assert.NoError(t, session.OnExecRequest(0, "ls"))
+	assert.NoError(t, session.OnExecRequest(1, "ls"))
+}
+
+func openSequencingTestSession(t *testing.T, config Config) *sessionHandler {
+	connection, _ := newTimeoutTestConnection(t, newFakeClock(), config)
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+	return session.(*sessionHandler)
+}
+
+func assertOutOfOrder(t *testing.T, err error) {
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.Equal(t, ERequestOutOfOrder, err.(log.Message).Code())
+}
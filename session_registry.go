@@ -0,0 +1,162 @@
+package security
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// SessionRegistry keeps track of the live connections and sessions handled by the security layer and allows closing
+// them. Pass it to New or NewHandler using WithSessionRegistry. The registry of a ConfigHolder is available through
+// ConfigHolder.Sessions. SessionRegistry is safe for concurrent use.
+type SessionRegistry struct {
+	lock        *sync.Mutex
+	connections map[*sshConnectionHandler]struct{}
+}
+
+// SessionInfo describes a live session.
+type SessionInfo struct {
+	// ConnectionID is the unique identifier of the connection, if known.
+	ConnectionID string `json:"connectionId"`
+	// ChannelID is the identifier of the session channel within the connection.
+	ChannelID uint64 `json:"channelId"`
+	// Username is the authenticated user.
+	Username string `json:"username"`
+	// RemoteAddress is the IP address of the client, if known.
+	RemoteAddress string `json:"remoteAddr"`
+	// StartTime is the time the session channel was opened.
+	StartTime time.Time `json:"startTime"`
+	// RequestType is the type of the program request of the session: exec, shell or subsystem. It is empty if no
+	// program was started yet.
+	RequestType string `json:"requestType,omitempty"`
+	// Program is the command or subsystem requested by the client.
+	Program string `json:"program,omitempty"`
+}
+
+// NewSessionRegistry creates an empty session registry.
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		lock:        &sync.Mutex{},
+		connections: map[*sshConnectionHandler]struct{}{},
+	}
+}
+
+// List returns the live sessions.
+func (r *SessionRegistry) List() []SessionInfo {
+	var result []SessionInfo
+	for _, connection := range r.connectionList() {
+		for _, session := range connection.listSessions() {
+			result = append(result, session.info())
+		}
+	}
+	return result
+}
+
+// CloseByUser closes all sessions of the user. The shutdown context is passed to the backend to allow for a graceful
+// termination. It returns the number of sessions closed.
+func (r *SessionRegistry) CloseByUser(shutdownContext context.Context, username string) int {
+	return r.closeMatching(shutdownContext, func(connection *sshConnectionHandler) bool {
+		return connection.username == username
+	}, "Closing the session of user %s by request.", username)
+}
+
+// CloseByIP closes all sessions from the client IP address. The shutdown context is passed to the backend to allow for
+// a graceful termination. It returns the number of sessions closed.
+func (r *SessionRegistry) CloseByIP(shutdownContext context.Context, ip net.IP) int {
+	return r.closeMatching(shutdownContext, func(connection *sshConnectionHandler) bool {
+		return connection.remoteAddress != nil && connection.remoteAddress.Equal(ip)
+	}, "Closing the session from %s by request.", ip.String())
+}
+
+// CloseByConnectionID closes all sessions of the connection. The shutdown context is passed to the backend to allow for
+// a graceful termination. It returns the number of sessions closed.
+func (r *SessionRegistry) CloseByConnectionID(shutdownContext context.Context, connectionID string) int {
+	return r.closeMatching(shutdownContext, func(connection *sshConnectionHandler) bool {
+		return connection.connectionID == connectionID
+	}, "Closing the session of connection %s by request.", connectionID)
+}
+
+func (r *SessionRegistry) closeMatching(
+	shutdownContext context.Context,
+	match func(connection *sshConnectionHandler) bool,
+	explanation string,
+	args ...interface{},
+) int {
+	var sessions []*sessionHandler
+	for _, connection := range r.connectionList() {
+		if match(connection) {
+			sessions = append(sessions, connection.listSessions()...)
+		}
+	}
+	lock := &sync.Mutex{}
+	terminated := 0
+	wg := &sync.WaitGroup{}
+	wg.Add(len(sessions))
+	for _, session := range sessions {
+		go func(session *sessionHandler) {
+			defer wg.Done()
+			if session.terminate(shutdownContext, log.UserMessage(
+				ESessionTerminated,
+				"Your session has been closed by the administrator.",
+				explanation,
+				args...,
+			), nil) {
+				lock.Lock()
+				terminated++
+				lock.Unlock()
+			}
+		}(session)
+	}
+	wg.Wait()
+	return terminated
+}
+
+// terminateViolating re-evaluates the program request of every live session against the current configuration of
+// its connection and terminates the sessions that would be rejected. It returns the number of sessions terminated.
+func (r *SessionRegistry) terminateViolating(shutdownContext context.Context) int {
+	var sessions []*sessionHandler
+	for _, connection := range r.connectionList() {
+		sessions = append(sessions, connection.listSessions()...)
+	}
+	lock := &sync.Mutex{}
+	terminated := 0
+	wg := &sync.WaitGroup{}
+	wg.Add(len(sessions))
+	for _, session := range sessions {
+		go func(session *sessionHandler) {
+			defer wg.Done()
+			if session.terminateIfViolating(shutdownContext) {
+				lock.Lock()
+				terminated++
+				lock.Unlock()
+			}
+		}(session)
+	}
+	wg.Wait()
+	return terminated
+}
+
+func (r *SessionRegistry) add(connection *sshConnectionHandler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.connections[connection] = struct{}{}
+}
+
+func (r *SessionRegistry) remove(connection *sshConnectionHandler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.connections, connection)
+}
+
+func (r *SessionRegistry) connectionList() []*sshConnectionHandler {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make([]*sshConnectionHandler, 0, len(r.connections))
+	for connection := range r.connections {
+		result = append(result, connection)
+	}
+	return result
+}
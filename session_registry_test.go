@@ -0,0 +1,187 @@
+package security
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/sshserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionRegistryClose(t *testing.T) {
+	registry := NewSessionRegistry()
+	h, err := NewHandler(Config{MaxSessions: -1}, &dummyHandler{}, log.NewTestLogger(t), WithSessionRegistry(registry))
+	assert.NoError(t, err)
+
+	session1, channel1 := openRegisteredSession(t, h, "127.0.0.1", "conn1", "user1")
+	_, channel2 := openRegisteredSession(t, h, "127.0.0.2", "conn2", "user2")
+	_, channel3 := openRegisteredSession(t, h, "127.0.0.3", "conn3", "user3")
+	assert.NoError(t, session1.OnExecRequest(1, "/bin/bash"))
+
+	sessions := registry.List()
+	assert.Equal(t, 3, len(sessions))
+	for _, session := range sessions {
+		if session.ConnectionID == "conn1" {
+			assert.Equal(t, "user1", session.Username)
+			assert.Equal(t, "127.0.0.1", session.RemoteAddress)
+			assert.Equal(t, "exec", session.RequestType)
+			assert.Equal(t, "/bin/bash", session.Program)
+		}
+	}
+
+	assert.Equal(t, 1, registry.CloseByUser(context.Background(), "user1"))
+	assert.Equal(t, 1, channel1.closeCount())
+	assert.Equal(t, 2, len(registry.List()))
+
+	// A session without requests is closed and rejects the request when its first request arrives.
+	assert.Equal(t, 1, registry.CloseByIP(context.Background(), net.ParseIP("127.0.0.2")))
+	assert.Equal(t, 0, channel2.closeCount())
+	assert.Error(t, channel2.handler.OnEnvRequest(1, "FOO", "bar"))
+	assert.Equal(t, 1, channel2.closeCount())
+	assert.Error(t, channel2.handler.OnEnvRequest(2, "FOO", "bar"))
+	assert.Equal(t, 1, channel2.closeCount())
+
+	assert.NoError(t, channel3.handler.OnShell(1))
+	assert.Equal(t, 1, registry.CloseByConnectionID(context.Background(), "conn3"))
+	assert.Equal(t, 1, channel3.closeCount())
+	assert.Equal(t, 0, registry.CloseByConnectionID(context.Background(), "conn3"))
+}
+
+func TestSessionRegistryCloseBeforeChannelOpen(t *testing.T) {
+	registry := NewSessionRegistry()
+	h, err := NewHandler(
+		Config{MaxSessions: -1},
+		&dummyHandler{},
+		log.NewTestLogger(t),
+		WithSessionRegistry(registry),
+	)
+	assert.NoError(t, err)
+	session, channel := openRegisteredSession(t, h, "127.0.0.1", "conn1", "user1")
+	channel.setOpen(false)
+
+	assert.Equal(t, 1, registry.CloseByUser(context.Background(), "user1"))
+	assert.Equal(t, 0, channel.closeCount())
+	assert.Equal(t, 1, len(registry.List()))
+	assert.Equal(t, 0, registry.CloseByUser(context.Background(), "user1"))
+
+	channel.setOpen(true)
+	assert.Error(t, session.OnEnvRequest(1, "FOO", "bar"))
+	assert.Equal(t, 1, channel.closeCount())
+	assert.Equal(t, 0, len(registry.List()))
+	assert.Error(t, session.OnShell(2))
+	assert.Equal(t, 1, channel.closeCount())
+}
+
+func TestTerminateViolatingSessions(t *testing.T) {
+	holder, err := NewConfigHolder(Config{MaxSessions: -1}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	h, err := NewHandler(Config{}, &dummyHandler{}, log.NewTestLogger(t), WithConfigHolder(holder))
+	assert.NoError(t, err)
+
+	bash, bashChannel := openRegisteredSession(t, h, "127.0.0.1", "conn1", "user")
+	ls, lsChannel := openRegisteredSession(t, h, "127.0.0.1", "conn2", "user")
+	assert.NoError(t, bash.OnExecRequest(1, "/bin/bash"))
+	assert.NoError(t, ls.OnExecRequest(1, "/bin/ls"))
+
+	var events []ConfigChangeEvent
+	holder.OnChange(func(event ConfigChangeEvent) {
+		events = append(events, event)
+	})
+	restricted := Config{
+		MaxSessions: -1,
+		Command: CommandConfig{
+			Mode:  ExecutionPolicyFilter,
+			Allow: []string{"/bin/ls"},
+		},
+		TerminateViolatingSessions: true,
+		Enforcement:                EnforcementModeAudit,
+	}
+	assert.NoError(t, holder.Reload(restricted))
+	assert.Equal(t, 0, events[0].TerminatedSessions)
+	assert.Equal(t, 0, bashChannel.closeCount())
+
+	restricted.Enforcement = EnforcementModeEnforce
+	assert.NoError(t, holder.Reload(restricted))
+	assert.Equal(t, 1, events[1].TerminatedSessions)
+	assert.Equal(t, 1, bashChannel.closeCount())
+	assert.Equal(t, 0, lsChannel.closeCount())
+	assert.Equal(t, 1, len(holder.Sessions().List()))
+}
+
+func TestTerminateViolatingSessionsSkipsStaleGeneration(t *testing.T) {
+	holder, err := NewConfigHolder(Config{MaxSessions: -1}, log.NewTestLogger(t))
+	assert.NoError(t, err)
+	h, err := NewHandler(Config{}, &dummyHandler{}, log.NewTestLogger(t), WithConfigHolder(holder))
+	assert.NoError(t, err)
+
+	bash, bashChannel := openRegisteredSession(t, h, "127.0.0.1", "conn1", "user")
+	assert.NoError(t, bash.OnExecRequest(1, "/bin/bash"))
+
+	restricted := Config{
+		MaxSessions:                -1,
+		Command:                    CommandConfig{Mode: ExecutionPolicyDisable},
+		TerminateViolatingSessions: true,
+	}.parse()
+	// A newer reload has replaced the configuration before this one was applied to the live sessions.
+	assert.NoError(t, holder.Reload(Config{MaxSessions: -1}))
+	reevaluated, terminated := holder.reevaluate(0, restricted)
+	assert.Equal(t, 0, reevaluated)
+	assert.Equal(t, 0, terminated)
+	assert.Equal(t, 0, bashChannel.closeCount())
+	assert.NoError(t, bash.OnExecRequest(2, "/bin/bash"))
+}
+
+func openRegisteredSession(
+	t *testing.T,
+	h sshserver.Handler,
+	ip string,
+	connectionID string,
+	username string,
+) (sshserver.SessionChannelHandler, *closableSessionChannel) {
+	network, err := h.OnNetworkConnection(net.TCPAddr{IP: net.ParseIP(ip)}, connectionID)
+	assert.NoError(t, err)
+	connection, err := network.OnHandshakeSuccess(username)
+	assert.NoError(t, err)
+	channel := &closableSessionChannel{lock: &sync.Mutex{}, open: true}
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+	return session, channel
+}
+
+// closableSessionChannel simulates the SSH server calling OnClose when the channel is closed. Like the sshserver
+// library it panics when it is closed before it is open.
+type closableSessionChannel struct {
+	sessionChannel
+	lock    *sync.Mutex
+	open    bool
+	closed  int
+	handler sshserver.SessionChannelHandler
+}
+
+func (c *closableSessionChannel) setOpen(open bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.open = open
+}
+
+func (c *closableSessionChannel) Close() error {
+	c.lock.Lock()
+	if !c.open {
+		c.lock.Unlock()
+		panic("channel closed before channel is open")
+	}
+	c.closed++
+	c.lock.Unlock()
+	c.handler.OnClose()
+	return nil
+}
+
+func (c *closableSessionChannel) closeCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
@@ -0,0 +1,225 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/sshserver"
+)
+
+// TimeoutExitStatus is the exit status sent to the client when a session is closed due to MaxSessionDuration or
+// IdleTimeout.
+const TimeoutExitStatus uint32 = 124
+
+// sessionTimeout enforces MaxSessionDuration and IdleTimeout on a session. Activity is reported by the
+// timeoutSessionChannel wrapping the channel passed to the backend.
+type sessionTimeout struct {
+	lock           *sync.Mutex
+	clock          Clock
+	session        *sessionHandler
+	maxDuration    time.Duration
+	idleTimeout    time.Duration
+	warning        time.Duration
+	gracePeriod    time.Duration
+	start          time.Time
+	lastActivity   time.Time
+	warnedDeadline time.Time
+	timer          ClockTimer
+	stopped        bool
+}
+
+func newSessionTimeout(config Config, clock Clock, session *sessionHandler) *sessionTimeout {
+	now := clock.Now()
+	return &sessionTimeout{
+		lock:         &sync.Mutex{},
+		clock:        clock,
+		session:      session,
+		maxDuration:  config.MaxSessionDuration,
+		idleTimeout:  config.IdleTimeout,
+		warning:      config.TimeoutWarning,
+		gracePeriod:  config.gracePeriod(),
+		start:        now,
+		lastActivity: now,
+	}
+}
+
+// startTimer schedules the first check.
+func (t *sessionTimeout) startTimer() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	deadline, _ := t.deadline()
+	t.schedule(t.clock.Now(), deadline)
+}
+
+// stop cancels the timeout, for example because the session was closed.
+func (t *sessionTimeout) stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// activity records input or output on the session.
+func (t *sessionTimeout) activity() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.lastActivity = t.clock.Now()
+}
+
+// deadline returns the time the session expires and the message code of the limit causing it. Must be called with the
+// lock held.
+func (t *sessionTimeout) deadline() (time.Time, string) {
+	var deadline time.Time
+	code := ""
+	if t.maxDuration > 0 {
+		deadline = t.start.Add(t.maxDuration)
+		code = ESessionDurationExceeded
+	}
+	if t.idleTimeout > 0 {
+		idleDeadline := t.lastActivity.Add(t.idleTimeout)
+		if code == "" || idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+			code = ESessionIdleTimeout
+		}
+	}
+	return deadline, code
+}
+
+// schedule starts the timer for the next check, either at the warning time or at the deadline. Must be called with the
+// lock held.
+func (t *sessionTimeout) schedule(now time.Time, deadline time.Time) {
+	next := deadline
+	if warnAt := deadline.Add(-t.warning); t.warning > 0 && now.Before(warnAt) {
+		next = warnAt
+	}
+	t.timer = t.clock.AfterFunc(next.Sub(now), t.check)
+}
+
+// check is called by the timer. It warns the user or closes the session if the deadline has been reached, and
+// reschedules itself otherwise.
+func (t *sessionTimeout) check() {
+	t.lock.Lock()
+	if t.stopped {
+		t.lock.Unlock()
+		return
+	}
+	now := t.clock.Now()
+	deadline, code := t.deadline()
+	if !now.Before(deadline) {
+		t.stopped = true
+		t.lock.Unlock()
+		t.expire(code)
+		return
+	}
+	warn := t.warning > 0 && !now.Before(deadline.Add(-t.warning)) && !t.warnedDeadline.Equal(deadline)
+	if warn {
+		t.warnedDeadline = deadline
+	}
+	t.schedule(now, deadline)
+	t.lock.Unlock()
+
+	if warn {
+		t.warn(code, deadline.Sub(now))
+	}
+}
+
+func (t *sessionTimeout) warn(code string, remaining time.Duration) {
+	reason := "it reached the maximum session duration"
+	if code == ESessionIdleTimeout {
+		reason = "of inactivity"
+	}
+	remaining = remaining.Round(time.Second)
+	message := log.UserMessage(
+		MSessionTimeoutWarning,
+		fmt.Sprintf("This session will be closed in %s because %s.", remaining, reason),
+		"Warning the user that the session will be closed in %s because %s.",
+		remaining,
+		reason,
+	)
+	t.session.sshConnection.addLabels(message)
+	t.session.logger.Debug(message.Label("channelId", t.session.channelID))
+	t.session.writeStderr(message.UserMessage())
+}
+
+func (t *sessionTimeout) expire(code string) {
+	var reason log.Message
+	if code == ESessionIdleTimeout {
+		reason = log.UserMessage(
+			ESessionIdleTimeout,
+			"Your session has been closed because it was idle for too long.",
+			"Closing the session because it had no input or output for %s.",
+			t.idleTimeout,
+		)
+	} else {
+		reason = log.UserMessage(
+			ESessionDurationExceeded,
+			"Your session has been closed because it reached the maximum session duration.",
+			"Closing the session because it has been open for %s.",
+			t.maxDuration,
+		)
+	}
+	t.session.writeStderr(reason.UserMessage())
+	shutdownContext, cancel := context.WithTimeout(context.Background(), t.gracePeriod)
+	defer cancel()
+	exitStatus := TimeoutExitStatus
+	t.session.terminate(shutdownContext, reason, &exitStatus)
+}
+
+// timeoutSessionChannel reports the input and output of a session to the session timeout.
+type timeoutSessionChannel struct {
+	sshserver.SessionChannel
+	timeout *sessionTimeout
+}
+
+func (c *timeoutSessionChannel) Stdin() io.Reader {
+	return &activityReader{
+		reader:  c.SessionChannel.Stdin(),
+		timeout: c.timeout,
+	}
+}
+
+func (c *timeoutSessionChannel) Stdout() io.Writer {
+	return &activityWriter{
+		writer:  c.SessionChannel.Stdout(),
+		timeout: c.timeout,
+	}
+}
+
+func (c *timeoutSessionChannel) Stderr() io.Writer {
+	return &activityWriter{
+		writer:  c.SessionChannel.Stderr(),
+		timeout: c.timeout,
+	}
+}
+
+type activityReader struct {
+	reader  io.Reader
+	timeout *sessionTimeout
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.reader.Read(p)
+	if n > 0 {
+		a.timeout.activity()
+	}
+	return n, err
+}
+
+type activityWriter struct {
+	writer  io.Writer
+	timeout *sessionTimeout
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	n, err := a.writer.Write(p)
+	if n > 0 {
+		a.timeout.activity()
+	}
+	return n, err
+}
@@ -0,0 +1,311 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/sshserver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	clock := newFakeClock()
+	connection, backend := newTimeoutTestConnection(t, clock, Config{
+		MaxSessions:    -1,
+		IdleTimeout:    5 * time.Minute,
+		TimeoutWarning: time.Minute,
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+	assert.NoError(t, session.OnShell(0))
+
+	clock.Advance(4 * time.Minute)
+	assert.Equal(t, "This session will be closed in 1m0s because of inactivity.\r\n", channel.stderrString())
+
+	// Output resets the idle timer.
+	_, err := backend.channel.Stdout().Write([]byte("hello"))
+	assert.NoError(t, err)
+	clock.Advance(3 * time.Minute)
+	assert.False(t, channel.isClosed())
+
+	clock.Advance(2 * time.Minute)
+	assert.True(t, channel.isClosed())
+	assert.Equal(t, []uint32{TimeoutExitStatus}, channel.exitStatuses())
+	assert.True(t, strings.HasSuffix(
+		channel.stderrString(),
+		"Your session has been closed because it was idle for too long.\r\n",
+	))
+	assert.Equal(t, uint(0), connection.sessionCount)
+}
+
+func TestMaxSessionDuration(t *testing.T) {
+	clock := newFakeClock()
+	connection, backend := newTimeoutTestConnection(t, clock, Config{
+		MaxSessions:        -1,
+		MaxSessionDuration: 10 * time.Minute,
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+	assert.NoError(t, session.OnShell(0))
+
+	for i := 0; i < 9; i++ {
+		clock.Advance(time.Minute)
+		_, err := backend.channel.Stdin().Read(make([]byte, 1))
+		assert.NoError(t, err)
+	}
+	assert.False(t, channel.isClosed())
+	clock.Advance(time.Minute)
+	assert.True(t, channel.isClosed())
+	assert.Equal(
+		t,
+		"Your session has been closed because it reached the maximum session duration.\r\n",
+		channel.stderrString(),
+	)
+}
+
+func TestTimeoutStoppedOnClose(t *testing.T) {
+	clock := newFakeClock()
+	connection, _ := newTimeoutTestConnection(t, clock, Config{
+		MaxSessions: -1,
+		IdleTimeout: time.Minute,
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+	session.OnClose()
+	clock.Advance(time.Hour)
+	assert.False(t, channel.isClosed())
+}
+
+func TestTimeoutWithoutRequest(t *testing.T) {
+	clock := newFakeClock()
+	connection, backend := newTimeoutTestConnection(t, clock, Config{
+		MaxSessions: -1,
+		IdleTimeout: time.Minute,
+	})
+	channel := newTimeoutTestChannel()
+	session, rejection := connection.OnSessionChannel(0, []byte{}, channel)
+	assert.Nil(t, rejection)
+	channel.handler = session
+
+	clock.Advance(time.Minute)
+	// The channel may not be open yet, so it is only closed when the first request arrives.
+	assert.False(t, channel.isClosed())
+	// The zero TerminationGracePeriod falls back to the default instead of an expired shutdown context.
+	assert.True(t, backend.session.shutdown)
+	assert.NoError(t, backend.session.shutdownErr)
+
+	assert.Error(t, session.OnShell(1))
+	assert.True(t, channel.isClosed())
+	assert.Empty(t, channel.exitStatuses())
+	assert.Equal(t, uint(0), connection.sessionCount)
+}
+
+func newTimeoutTestConnection(
+	t *testing.T,
+	clock Clock,
+	config Config,
+) (*sshConnectionHandler, *channelCapturingBackend) {
+	backend := &channelCapturingBackend{}
+	return &sshConnectionHandler{
+		config:  config,
+		backend: backend,
+		lock:    &sync.Mutex{},
+		logger:  log.NewTestLogger(t),
+		options: newOptions([]Option{WithClock(clock)}),
+	}, backend
+}
+
+// channelCapturingBackend records the session channel passed by the security layer.
+type channelCapturingBackend struct {
+	dummySSHBackend
+	channel sshserver.SessionChannel
+	session *shutdownRecordingBackend
+}
+
+// shutdownRecordingBackend records whether the session was shut down and the state of the shutdown context.
+type shutdownRecordingBackend struct {
+	dummyBackend
+	shutdown    bool
+	shutdownErr error
+}
+
+func (s *shutdownRecordingBackend) OnShutdown(shutdownContext context.Context) {
+	s.shutdown = true
+	s.shutdownErr = shutdownContext.Err()
+}
+
+func (c *channelCapturingBackend) OnSessionChannel(
+	_ uint64,
+	_ []byte,
+	session sshserver.SessionChannel,
+) (channel sshserver.SessionChannelHandler, failureReason sshserver.ChannelRejection) {
+	c.channel = session
+	c.session = &shutdownRecordingBackend{}
+	return c.session, nil
+}
+
+// timeoutTestChannel records the output, exit status and closing of a session channel.
+type timeoutTestChannel struct {
+	lock    *sync.Mutex
+	stderr  *bytes.Buffer
+	exits   []uint32
+	closed  bool
+	handler sshserver.SessionChannelHandler
+}
+
+func newTimeoutTestChannel() *timeoutTestChannel {
+	return &timeoutTestChannel{
+		lock:   &sync.Mutex{},
+		stderr: &bytes.Buffer{},
+	}
+}
+
+func (c *timeoutTestChannel) Stdin() io.Reader {
+	return strings.NewReader("input")
+}
+
+func (c *timeoutTestChannel) Stdout() io.Writer {
+	return io.Discard
+}
+
+func (c *timeoutTestChannel) Stderr() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.stderr.Write(p)
+	})
+}
+
+func (c *timeoutTestChannel) ExitStatus(exitCode uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.exits = append(c.exits, exitCode)
+}
+
+func (c *timeoutTestChannel) ExitSignal(_ string, _ bool, _ string, _ string) {
+}
+
+func (c *timeoutTestChannel) CloseWrite() error {
+	return nil
+}
+
+func (c *timeoutTestChannel) Close() error {
+	c.lock.Lock()
+	c.closed = true
+	c.lock.Unlock()
+	c.handler.OnClose()
+	return nil
+}
+
+func (c *timeoutTestChannel) stderrString() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stderr.String()
+}
+
+func (c *timeoutTestChannel) exitStatuses() []uint32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.exits
+}
+
+func (c *timeoutTestChannel) isClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (w writerFunc) Write(p []byte) (int, error) {
+	return w(p)
+}
+
+// fakeClock is a manually advanced clock. Timers fire synchronously during Advance, in order of their due time.
+type fakeClock struct {
+	lock   *sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		lock: &sync.Mutex{},
+		now:  time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) AfterFunc(duration time.Duration, fn func()) ClockTimer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	timer := &fakeTimer{clock: f, due: f.now.Add(duration), f: fn}
+	f.timers = append(f.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward and fires the timers that become due.
+func (f *fakeClock) Advance(duration time.Duration) {
+	f.lock.Lock()
+	target := f.now.Add(duration)
+	f.lock.Unlock()
+	for {
+		f.lock.Lock()
+		sort.SliceStable(f.timers, func(i, j int) bool {
+			return f.timers[i].due.Before(f.timers[j].due)
+		})
+		if len(f.timers) == 0 || f.timers[0].due.After(target) {
+			f.now = target
+			f.lock.Unlock()
+			return
+		}
+		timer := f.timers[0]
+		f.timers = f.timers[1:]
+		f.now = timer.due
+		f.lock.Unlock()
+		timer.f()
+	}
+}
+
+// pending returns the number of timers that have not fired yet.
+func (f *fakeClock) pending() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.timers)
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	due   time.Time
+	f     func()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,403 @@
+package security
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/containerssh/log"
+)
+
+// TTYBounds limits a dimension of a TTY request. 0 means no limit.
+type TTYBounds struct {
+	// Min is the smallest allowed value.
+	Min uint32 `json:"min" yaml:"min"`
+	// Max is the largest allowed value.
+	Max uint32 `json:"max" yaml:"max"`
+}
+
+// Validate validates the bounds.
+func (b TTYBounds) Validate() error {
+	if b.Max > 0 && b.Min > b.Max {
+		return fmt.Errorf("min (%d) is larger than max (%d)", b.Min, b.Max)
+	}
+	return nil
+}
+
+func (b TTYBounds) contains(value uint32) bool {
+	if value < b.Min {
+		return false
+	}
+	return b.Max == 0 || value <= b.Max
+}
+
+// TTYModeAction describes what happens to a terminal mode matching a TTYModeRule.
+type TTYModeAction string
+
+const (
+	// TTYModeActionStrip removes the mode from the mode list before passing the request to the backend.
+	TTYModeActionStrip TTYModeAction = "strip"
+	// TTYModeActionReject rejects the TTY request.
+	TTYModeActionReject TTYModeAction = "reject"
+)
+
+// Validate validates the TTY mode action.
+func (t TTYModeAction) Validate() error {
+	switch t {
+	case TTYModeActionStrip:
+	case TTYModeActionReject:
+	default:
+		return fmt.Errorf("invalid TTY mode action: %s", t)
+	}
+	return nil
+}
+
+// TTYModeRule filters a terminal mode in the encoded mode list of a TTY request as described in RFC 4254 section 8.
+type TTYModeRule struct {
+	// Mode is the name of the mode as listed in RFC 4254 section 8, e.g. `ECHO` or `VINTR`, or its numeric opcode.
+	Mode string `json:"mode" yaml:"mode"`
+	// AllowValues lists the values the mode may have. If set, the rule only applies to other values. If empty, the
+	// rule applies to every occurrence of the mode.
+	AllowValues []uint32 `json:"allowValues" yaml:"allowValues"`
+	// Action configures whether the mode is stripped or the request is rejected.
+	Action TTYModeAction `json:"action" yaml:"action"`
+}
+
+// Validate validates the TTY mode rule.
+func (t TTYModeRule) Validate() error {
+	if _, err := parseTTYOpcode(t.Mode); err != nil {
+		return err
+	}
+	if err := t.Action.Validate(); err != nil {
+		return fmt.Errorf("invalid action (%w)", err)
+	}
+	return nil
+}
+
+// matches returns true if the rule applies to the mode. The opcode is the resolved Mode of the rule.
+func (t TTYModeRule) matches(opcode byte, mode ttyMode) bool {
+	if opcode != mode.opcode {
+		return false
+	}
+	for _, value := range t.AllowValues {
+		if value == mode.value {
+			return false
+		}
+	}
+	return true
+}
+
+// ttyOpcodeEnd terminates the encoded mode list.
+const ttyOpcodeEnd byte = 0
+
+// ttyOpcodeLastDefined is the last opcode with an uint32 argument. Opcodes above this stop the parsing.
+const ttyOpcodeLastDefined byte = 159
+
+// ttyModeNames contains the terminal modes defined in RFC 4254 section 8 and RFC 8160.
+var ttyModeNames = map[string]byte{
+	"VINTR":         1,
+	"VQUIT":         2,
+	"VERASE":        3,
+	"VKILL":         4,
+	"VEOF":          5,
+	"VEOL":          6,
+	"VEOL2":         7,
+	"VSTART":        8,
+	"VSTOP":         9,
+	"VSUSP":         10,
+	"VDSUSP":        11,
+	"VREPRINT":      12,
+	"VWERASE":       13,
+	"VLNEXT":        14,
+	"VFLUSH":        15,
+	"VSWTCH":        16,
+	"VSTATUS":       17,
+	"VDISCARD":      18,
+	"IGNPAR":        30,
+	"PARMRK":        31,
+	"INPCK":         32,
+	"ISTRIP":        33,
+	"INLCR":         34,
+	"IGNCR":         35,
+	"ICRNL":         36,
+	"IUCLC":         37,
+	"IXON":          38,
+	"IXANY":         39,
+	"IXOFF":         40,
+	"IMAXBEL":       41,
+	"IUTF8":         42,
+	"ISIG":          50,
+	"ICANON":        51,
+	"XCASE":         52,
+	"ECHO":          53,
+	"ECHOE":         54,
+	"ECHOK":         55,
+	"ECHONL":        56,
+	"NOFLSH":        57,
+	"TOSTOP":        58,
+	"IEXTEN":        59,
+	"ECHOCTL":       60,
+	"ECHOKE":        61,
+	"PENDIN":        62,
+	"OPOST":         70,
+	"OLCUC":         71,
+	"ONLCR":         72,
+	"OCRNL":         73,
+	"ONOCR":         74,
+	"ONLRET":        75,
+	"CS7":           90,
+	"CS8":           91,
+	"PARENB":        92,
+	"PARODD":        93,
+	"TTY_OP_ISPEED": 128,
+	"TTY_OP_OSPEED": 129,
+}
+
+// parseTTYOpcode returns the opcode for a mode name or a numeric opcode.
+func parseTTYOpcode(mode string) (byte, error) {
+	if opcode, ok := ttyModeNames[strings.ToUpper(mode)]; ok {
+		return opcode, nil
+	}
+	opcode, err := strconv.ParseUint(mode, 10, 8)
+	if err != nil || opcode == uint64(ttyOpcodeEnd) || opcode > uint64(ttyOpcodeLastDefined) {
+		return 0, fmt.Errorf("invalid TTY mode: %s", mode)
+	}
+	return byte(opcode), nil
+}
+
+// ttyModeName returns the name of the opcode for log messages.
+func ttyModeName(opcode byte) string {
+	for name, o := range ttyModeNames {
+		if o == opcode {
+			return name
+		}
+	}
+	return strconv.Itoa(int(opcode))
+}
+
+// ttyMode is a single entry of the encoded mode list.
+type ttyMode struct {
+	opcode byte
+	value  uint32
+}
+
+// decodeTTYModes decodes the mode list of a TTY request. Decoding stops at TTY_OP_END or at an opcode without a defined
+// argument, the remaining bytes starting with that opcode are returned as they are.
+func decodeTTYModes(modeList []byte) ([]ttyMode, []byte, error) {
+	var modes []ttyMode
+	for i := 0; i < len(modeList); {
+		opcode := modeList[i]
+		if opcode == ttyOpcodeEnd || opcode > ttyOpcodeLastDefined {
+			return modes, modeList[i:], nil
+		}
+		if i+5 > len(modeList) {
+			return nil, nil, fmt.Errorf("truncated argument for TTY mode %s", ttyModeName(opcode))
+		}
+		modes = append(modes, ttyMode{opcode: opcode, value: binary.BigEndian.Uint32(modeList[i+1 : i+5])})
+		i += 5
+	}
+	return modes, nil, nil
+}
+
+// encodeTTYModes encodes the modes and appends the remaining bytes returned by decodeTTYModes.
+func encodeTTYModes(modes []ttyMode, rest []byte) []byte {
+	result := make([]byte, 0, len(modes)*5+len(rest)+1)
+	for _, mode := range modes {
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, mode.value)
+		result = append(result, mode.opcode)
+		result = append(result, value...)
+	}
+	if len(rest) == 0 {
+		return append(result, ttyOpcodeEnd)
+	}
+	return append(result, rest...)
+}
+
+// ttyRequest is a pty-req request as described in RFC 4254 section 6.2.
+type ttyRequest struct {
+	term     string
+	columns  uint32
+	rows     uint32
+	width    uint32
+	height   uint32
+	modeList []byte
+}
+
+// filter checks the request against the filter settings of the TTY configuration. It returns the rule that decided
+// the outcome, the stripped modes, the mode list to pass to the backend, and the reason of the rejection, if any.
+func (t TTYConfig) filter(
+	parsed *parsedConfig,
+	request ttyRequest,
+) (rule string, stripped []string, modeList []byte, reason string) {
+	var ok bool
+	if rule, ok = parsed.matchAny(t.Allow, request.term); !ok {
+		return "", nil, nil, fmt.Sprintf("the terminal type %s does not match the allow list", request.term)
+	}
+	dimensions := []struct {
+		name   string
+		value  uint32
+		bounds TTYBounds
+	}{
+		{"columns", request.columns, t.Columns},
+		{"rows", request.rows, t.Rows},
+		{"width", request.width, t.Width},
+		{"height", request.height, t.Height},
+	}
+	for _, dimension := range dimensions {
+		if !dimension.bounds.contains(dimension.value) {
+			return dimension.name, nil, nil, fmt.Sprintf(
+				"the %s value %d is outside the allowed range",
+				dimension.name,
+				dimension.value,
+			)
+		}
+	}
+	if len(t.Modes) == 0 {
+		return rule, nil, request.modeList, ""
+	}
+	modes, rest, err := decodeTTYModes(request.modeList)
+	if err != nil {
+		return "", nil, nil, fmt.Sprintf("the mode list is malformed (%v)", err)
+	}
+	opcodes := make([]byte, len(t.Modes))
+	for i, modeRule := range t.Modes {
+		// Invalid rules are rejected by Validate. Should one get here, it resolves to TTY_OP_END, which never matches.
+		opcodes[i], _ = parsed.ttyOpcode(modeRule.Mode)
+	}
+	kept := make([]ttyMode, 0, len(modes))
+	for _, mode := range modes {
+		action := TTYModeAction("")
+		for i, modeRule := range t.Modes {
+			if modeRule.matches(opcodes[i], mode) {
+				action = modeRule.Action
+				break
+			}
+		}
+		switch action {
+		case TTYModeActionReject:
+			return "modes:" + ttyModeName(mode.opcode), nil, nil, fmt.Sprintf(
+				"the mode list sets %s to %d",
+				ttyModeName(mode.opcode),
+				mode.value,
+			)
+		case TTYModeActionStrip:
+			stripped = append(stripped, ttyModeName(mode.opcode))
+		default:
+			kept = append(kept, mode)
+		}
+	}
+	if len(stripped) == 0 {
+		return rule, nil, request.modeList, ""
+	}
+	return rule, stripped, encodeTTYModes(kept, rest), ""
+}
+
+// checkPty evaluates a TTY request against the policy. It returns the mode list to pass to the backend, which may have
+// modes stripped in filter mode.
+func (c Config) checkPty(request ttyRequest) (Decision, []byte, log.Message) {
+	mode := c.getPolicy(c.TTY.Mode)
+	decision := Decision{RequestType: "pty-req", Subject: request.term, Mode: mode}
+	if mode == ExecutionPolicyDisable {
+		return decision, request.modeList, log.UserMessage(
+			ETTYRejected,
+			"TTY allocation disabled.",
+			"TTY allocation is disabled in the security settings.",
+		)
+	}
+	if rule, ok := c.parsed.matchAny(c.TTY.Deny, request.term); ok {
+		decision.MatchedRule = rule
+		return decision, request.modeList, log.UserMessage(
+			ETTYRejected,
+			"TTY allocation rejected.",
+			"TTY allocation is rejected because the terminal type %s matches the deny list.",
+			request.term,
+		).Label("term", request.term)
+	}
+	if mode != ExecutionPolicyFilter {
+		return decision, request.modeList, nil
+	}
+	rule, stripped, modeList, reason := c.TTY.filter(c.parsed, request)
+	decision.MatchedRule = rule
+	if reason != "" {
+		return decision, request.modeList, log.UserMessage(
+			ETTYRejected,
+			"TTY allocation rejected.",
+			"TTY allocation is rejected because %s.",
+			reason,
+		).Label("term", request.term)
+	}
+	if len(stripped) > 0 {
+		decision.Outcome = DecisionOutcomeRewrite
+		decision.Rewrite = "strip " + strings.Join(stripped, ",")
+	}
+	return decision, modeList, nil
+}
+
+// ttyRequirement returns the RequireTTY and ForbidTTY settings of the section of a program request. For exec requests
+// the settings of the command rule that allowed the command, if any, also apply.
+func (c Config) ttyRequirement(requestType string, rule *CommandRule) (require bool, forbid bool) {
+	switch requestType {
+	case "exec":
+		require, forbid = c.Command.RequireTTY, c.Command.ForbidTTY
+		if rule != nil {
+			require = require || rule.RequireTTY
+			forbid = forbid || rule.ForbidTTY
+		}
+	case "shell":
+		require, forbid = c.Shell.RequireTTY, c.Shell.ForbidTTY
+	case "subsystem":
+		require, forbid = c.Subsystem.RequireTTY, c.Subsystem.ForbidTTY
+	}
+	return require, forbid
+}
+
+// checkTTYRequirement checks whether a program request is allowed with or without a TTY according to the RequireTTY
+// and ForbidTTY settings of its section. For exec requests the rule is the command rule returned by checkExec.
+func (c Config) checkTTYRequirement(
+	decision Decision,
+	requestType string,
+	rule *CommandRule,
+	tty bool,
+) (Decision, log.Message) {
+	require, forbid := c.ttyRequirement(requestType, rule)
+	switch {
+	case require && !tty:
+		decision.MatchedRule = "requireTTY"
+		return decision, log.UserMessage(
+			ETTYRequired,
+			"This program requires a TTY, please request one, e.g. using ssh -t.",
+			"The %s request is rejected because it requires a TTY and none has been allocated.",
+			requestType,
+		)
+	case forbid && tty:
+		decision.MatchedRule = "forbidTTY"
+		return decision, log.UserMessage(
+			ETTYForbidden,
+			"This program must be run without a TTY, please do not request one, e.g. using ssh -T.",
+			"The %s request is rejected because it must not have a TTY and one has been allocated.",
+			requestType,
+		)
+	}
+	return decision, nil
+}
+
+// checkPtyForbidden rejects a TTY request if the program running in the session must not have a TTY. Without strict
+// sequencing clients may still request a TTY after the program has been started.
+func (s *sessionHandler) checkPtyForbidden(decision Decision) (Decision, log.Message) {
+	s.stateLock.Lock()
+	forbid := s.forbidTTY
+	requestType := s.requestType
+	s.stateLock.Unlock()
+	if !forbid {
+		return decision, nil
+	}
+	decision.MatchedRule = "forbidTTY"
+	return decision, log.UserMessage(
+		ETTYForbidden,
+		"The running program must be run without a TTY.",
+		"The TTY request is rejected because the program started by the %s request must not have a TTY.",
+		requestType,
+	)
+}
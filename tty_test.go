@@ -0,0 +1,168 @@
+package security
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTYModeDecoding(t *testing.T) {
+	modeList := []byte{
+		53, 0, 0, 0, 1, // ECHO 1
+		128, 0, 0, 0x96, 0, // TTY_OP_ISPEED 38400
+		0,
+	}
+	modes, rest, err := decodeTTYModes(modeList)
+	assert.NoError(t, err)
+	assert.Equal(t, []ttyMode{{opcode: 53, value: 1}, {opcode: 128, value: 38400}}, modes)
+	assert.Equal(t, []byte{0}, rest)
+	assert.Equal(t, modeList, encodeTTYModes(modes, rest))
+
+	_, _, err = decodeTTYModes([]byte{53, 0, 0})
+	assert.Error(t, err)
+
+	modes, rest, err = decodeTTYModes([]byte{})
+	assert.NoError(t, err)
+	assert.Empty(t, modes)
+	assert.Equal(t, []byte{0}, encodeTTYModes(modes, rest))
+}
+
+func TestPTYRequestFilter(t *testing.T) {
+	backend := &ptyRecordingBackend{}
+	session := &sessionHandler{
+		config: Config{
+			TTY: TTYConfig{
+				Mode:    ExecutionPolicyFilter,
+				Allow:   []string{"glob:xterm*", "vt100"},
+				Deny:    []string{"xterm-evil"},
+				Columns: TTYBounds{Min: 20, Max: 500},
+				Rows:    TTYBounds{Max: 200},
+				Modes: []TTYModeRule{
+					{Mode: "ECHO", AllowValues: []uint32{1}, Action: TTYModeActionReject},
+					{Mode: "VINTR", Action: TTYModeActionStrip},
+				},
+			},
+		},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	assert.NoError(t, session.OnPtyRequest(1, "xterm-256color", 80, 25, 800, 600, []byte{}))
+	assert.Error(t, session.OnPtyRequest(2, "xterm-evil", 80, 25, 800, 600, []byte{}))
+	assert.Error(t, session.OnPtyRequest(3, "screen", 80, 25, 800, 600, []byte{}))
+	assert.Error(t, session.OnPtyRequest(4, "vt100", 10, 25, 800, 600, []byte{}))
+	assert.Error(t, session.OnPtyRequest(5, "vt100", 80, 1000, 800, 600, []byte{}))
+	assert.Error(t, session.OnPtyRequest(6, "vt100", 80, 25, 800, 600, []byte{53, 0, 0, 0, 0, 0}))
+	assert.Error(t, session.OnPtyRequest(7, "vt100", 80, 25, 800, 600, []byte{53, 0}))
+
+	assert.NoError(t, session.OnPtyRequest(8, "vt100", 80, 25, 800, 600, []byte{
+		1, 0, 0, 0, 3, // VINTR ^C
+		53, 0, 0, 0, 1, // ECHO 1
+		0,
+	}))
+	assert.Equal(t, []byte{53, 0, 0, 0, 1, 0}, backend.modeList)
+
+	session.config.TTY.Mode = ExecutionPolicyEnable
+	assert.NoError(t, session.OnPtyRequest(9, "screen", 10, 25, 800, 600, []byte{53, 0}))
+	assert.Error(t, session.OnPtyRequest(10, "xterm-evil", 80, 25, 800, 600, []byte{}))
+}
+
+func TestTTYValidation(t *testing.T) {
+	assert.Error(t, TTYConfig{Columns: TTYBounds{Min: 100, Max: 10}}.Validate())
+	assert.Error(t, TTYConfig{Modes: []TTYModeRule{{Mode: "FOO", Action: TTYModeActionStrip}}}.Validate())
+	assert.Error(t, TTYConfig{Modes: []TTYModeRule{{Mode: "200", Action: TTYModeActionStrip}}}.Validate())
+	assert.Error(t, TTYConfig{Modes: []TTYModeRule{{Mode: "ECHO"}}}.Validate())
+	assert.NoError(t, TTYConfig{Modes: []TTYModeRule{{Mode: "53", Action: TTYModeActionReject}}}.Validate())
+}
+
+func TestTTYOpcodesAreParsedOnce(t *testing.T) {
+	config := Config{
+		TTY: TTYConfig{Modes: []TTYModeRule{{Mode: "53", Action: TTYModeActionReject}}},
+		Users: map[string]OverrideConfig{
+			"user": {TTY: &TTYConfig{Modes: []TTYModeRule{{Mode: "echo", Action: TTYModeActionStrip}}}},
+		},
+	}.parse()
+	assert.Equal(t, map[string]byte{"53": 53, "echo": 53}, config.parsed.ttyOpcodes)
+}
+
+func TestTTYRequirement(t *testing.T) {
+	config := Config{
+		Shell: ShellConfig{RequireTTY: true},
+		Command: CommandConfig{
+			Mode:  ExecutionPolicyFilter,
+			Allow: []string{"backup"},
+			Rules: []CommandRule{
+				{Program: "top", RequireTTY: true},
+				{Program: "rsync", RemainingArgs: "glob:*", ForbidTTY: true},
+			},
+		},
+		Subsystem: SubsystemConfig{ForbidTTY: true},
+	}
+
+	session, _ := newForceCommandTestSession(t, config)
+	err := session.OnShell(1)
+	assert.Error(t, err)
+	assert.Equal(t, ETTYRequired, err.(log.Message).Code())
+	assert.Error(t, session.OnExecRequest(2, "top"))
+	assert.NoError(t, session.OnExecRequest(3, "rsync --server ."))
+	assert.NoError(t, session.OnExecRequest(4, "backup"))
+	assert.NoError(t, session.OnSubsystem(5, "sftp"))
+
+	session, _ = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnPtyRequest(1, "xterm", 80, 25, 800, 600, []byte{}))
+	assert.NoError(t, session.OnShell(2))
+	assert.NoError(t, session.OnExecRequest(3, "top"))
+	err = session.OnExecRequest(4, "rsync --server .")
+	assert.Error(t, err)
+	assert.Equal(t, ETTYForbidden, err.(log.Message).Code())
+	assert.NoError(t, session.OnExecRequest(5, "backup"))
+	assert.Error(t, session.OnSubsystem(6, "sftp"))
+
+	// Without strict sequencing a TTY may be requested after the program was started.
+	session, _ = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnExecRequest(1, "rsync --server ."))
+	err = session.OnPtyRequest(2, "xterm", 80, 25, 800, 600, []byte{})
+	assert.Error(t, err)
+	assert.Equal(t, ETTYForbidden, err.(log.Message).Code())
+	assert.False(t, session.hasPTY())
+
+	session, _ = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnExecRequest(1, "backup"))
+	assert.NoError(t, session.OnPtyRequest(2, "xterm", 80, 25, 800, 600, []byte{}))
+
+	config.Command.ForbidTTY = true
+	session, _ = newForceCommandTestSession(t, config)
+	assert.NoError(t, session.OnPtyRequest(1, "xterm", 80, 25, 800, 600, []byte{}))
+	assert.Error(t, session.OnExecRequest(2, "backup"))
+}
+
+func TestTTYRequirementValidation(t *testing.T) {
+	assert.Error(t, ShellConfig{RequireTTY: true, ForbidTTY: true}.Validate())
+	assert.Error(t, CommandConfig{RequireTTY: true, ForbidTTY: true}.Validate())
+	assert.Error(t, SubsystemConfig{RequireTTY: true, ForbidTTY: true}.Validate())
+	assert.Error(t, CommandRule{Program: "top", RequireTTY: true, ForbidTTY: true}.Validate())
+}
+
+// ptyRecordingBackend records the mode list of the last TTY request.
+type ptyRecordingBackend struct {
+	dummyBackend
+	modeList []byte
+}
+
+func (p *ptyRecordingBackend) OnPtyRequest(
+	_ uint64,
+	_ string,
+	_ uint32,
+	_ uint32,
+	_ uint32,
+	_ uint32,
+	modeList []byte,
+) error {
+	p.modeList = modeList
+	return nil
+}
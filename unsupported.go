@@ -0,0 +1,83 @@
+package security
+
+import (
+	"fmt"
+)
+
+// UnsupportedConfig controls channel types, global requests, and channel requests that the sshserver library does not
+// handle itself and that are not covered by a dedicated section such as Forwarding or X11. Each of these falls back
+// to DefaultMode, so setting DefaultMode to ExecutionPolicyDisable blocks new protocol features by default.
+type UnsupportedConfig struct {
+	// ChannelTypes controls channel types other than `session` and `direct-tcpip`, e.g.
+	// `direct-streamlocal@openssh.com`.
+	ChannelTypes RequestPolicyConfig `json:"channelTypes" yaml:"channelTypes"`
+	// GlobalRequests controls global requests other than `tcpip-forward` and `cancel-tcpip-forward`, e.g.
+	// `keepalive@openssh.com`.
+	GlobalRequests RequestPolicyConfig `json:"globalRequests" yaml:"globalRequests"`
+	// ChannelRequests controls session channel requests other than the ones decoded by the sshserver library and
+	// `x11-req` and `auth-agent-req@openssh.com`.
+	ChannelRequests RequestPolicyConfig `json:"channelRequests" yaml:"channelRequests"`
+}
+
+// Validate validates the configuration for unsupported channels and requests.
+func (u UnsupportedConfig) Validate() error {
+	if err := u.ChannelTypes.Validate(); err != nil {
+		return fmt.Errorf("invalid channelTypes configuration (%w)", err)
+	}
+	if err := u.GlobalRequests.Validate(); err != nil {
+		return fmt.Errorf("invalid globalRequests configuration (%w)", err)
+	}
+	if err := u.ChannelRequests.Validate(); err != nil {
+		return fmt.Errorf("invalid channelRequests configuration (%w)", err)
+	}
+	return nil
+}
+
+// RequestPolicyConfig controls channel types or requests by name.
+type RequestPolicyConfig struct {
+	// Mode configures how to treat the channels or requests.
+	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Allow takes effect when Mode is ExecutionPolicyFilter and only allows the specified names. Entries may be
+	// prefixed with PatternPrefixGlob or PatternPrefixRegex.
+	Allow []string `json:"allow" yaml:"allow"`
+	// Deny takes effect when Mode is not ExecutionPolicyDisable and rejects the specified names.
+	Deny []string `json:"deny" yaml:"deny"`
+}
+
+// Validate validates the request policy.
+func (r RequestPolicyConfig) Validate() error {
+	if err := r.Mode.Validate(); err != nil {
+		return fmt.Errorf("invalid mode (%w)", err)
+	}
+	if err := validatePatterns(r.Allow); err != nil {
+		return fmt.Errorf("invalid allow list (%w)", err)
+	}
+	if err := validatePatterns(r.Deny); err != nil {
+		return fmt.Errorf("invalid deny list (%w)", err)
+	}
+	return nil
+}
+
+// check evaluates a channel type or request name against the policy. It returns the list entry that decided the
+// outcome, if any, and the reason of the rejection, or an empty string if the name is allowed.
+func (r RequestPolicyConfig) check(
+	parsed *parsedConfig,
+	mode ExecutionPolicy,
+	name string,
+) (rule string, reason string) {
+	switch mode {
+	case ExecutionPolicyDisable:
+		return "", fmt.Sprintf("%s is disabled in the security settings.", name)
+	case ExecutionPolicyFilter:
+		var ok bool
+		if rule, ok = parsed.matchAny(r.Allow, name); !ok {
+			return "", fmt.Sprintf("%s does not match the allow list.", name)
+		}
+		fallthrough
+	default:
+		if denied, ok := parsed.matchAny(r.Deny, name); ok {
+			return denied, fmt.Sprintf("%s matches the deny list.", name)
+		}
+	}
+	return rule, ""
+}
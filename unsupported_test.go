@@ -0,0 +1,73 @@
+package security
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestUnsupportedDefaultDeny(t *testing.T) {
+	backend := &dummySSHBackend{}
+	connection := &sshConnectionHandler{
+		config: Config{
+			DefaultMode: ExecutionPolicyDisable,
+			Forwarding: ForwardingConfig{
+				Local: TCPForwardingConfig{Mode: ExecutionPolicyEnable},
+			},
+			Unsupported: UnsupportedConfig{
+				GlobalRequests: RequestPolicyConfig{
+					Mode:  ExecutionPolicyFilter,
+					Allow: []string{"keepalive@openssh.com"},
+				},
+			},
+		},
+		backend: backend,
+		lock:    &sync.Mutex{},
+		logger:  log.NewTestLogger(t),
+	}
+
+	connection.OnUnsupportedChannel(1, "direct-streamlocal@openssh.com", []byte{})
+	connection.OnUnsupportedChannel(2, "direct-tcpip", ssh.Marshal(directTCPIPPayload{"127.0.0.1", 80, "", 0}))
+	assert.Equal(t, []string{"direct-tcpip"}, backend.unsupportedChannels)
+	_, rejection := connection.checkChannelType("direct-streamlocal@openssh.com")
+	assert.Equal(t, ssh.Prohibited, rejection.Reason())
+
+	connection.OnUnsupportedGlobalRequest(1, "keepalive@openssh.com", []byte{})
+	connection.OnUnsupportedGlobalRequest(2, "no-more-sessions@openssh.com", []byte{})
+	assert.Equal(t, []string{"keepalive@openssh.com"}, backend.globalRequests)
+
+	sessionBackend := &dummyBackend{}
+	session := &sessionHandler{
+		config:        connection.config,
+		backend:       sessionBackend,
+		sshConnection: connection,
+		logger:        log.NewTestLogger(t),
+	}
+	session.OnUnsupportedChannelRequest(1, "eow@openssh.com", []byte{})
+	assert.Empty(t, sessionBackend.unsupportedRequests)
+
+	session.config.Unsupported.ChannelRequests = RequestPolicyConfig{
+		Mode: ExecutionPolicyEnable,
+		Deny: []string{"glob:*@example.com"},
+	}
+	session.OnUnsupportedChannelRequest(2, "eow@openssh.com", []byte{})
+	session.OnUnsupportedChannelRequest(3, "custom@example.com", []byte{})
+	assert.Equal(t, []string{"eow@openssh.com"}, sessionBackend.unsupportedRequests)
+}
+
+func TestUnsupportedPassThroughByDefault(t *testing.T) {
+	backend := &dummySSHBackend{}
+	connection := &sshConnectionHandler{
+		config:  Config{},
+		backend: backend,
+		lock:    &sync.Mutex{},
+		logger:  log.NewTestLogger(t),
+	}
+	connection.OnUnsupportedChannel(1, "direct-streamlocal@openssh.com", []byte{})
+	connection.OnUnsupportedGlobalRequest(1, "keepalive@openssh.com", []byte{})
+	assert.Equal(t, []string{"direct-streamlocal@openssh.com"}, backend.unsupportedChannels)
+	assert.Equal(t, []string{"keepalive@openssh.com"}, backend.globalRequests)
+}
@@ -0,0 +1,213 @@
+package security
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// TTYResizeAction describes what happens to window change requests outside the TTY bounds.
+type TTYResizeAction string
+
+const (
+	// TTYResizeActionUnconfigured falls back to TTYResizeActionReject.
+	TTYResizeActionUnconfigured TTYResizeAction = ""
+	// TTYResizeActionReject rejects window changes outside the bounds.
+	TTYResizeActionReject TTYResizeAction = "reject"
+	// TTYResizeActionClamp changes the dimensions to the nearest value within the bounds.
+	TTYResizeActionClamp TTYResizeAction = "clamp"
+)
+
+// Validate validates the resize action.
+func (t TTYResizeAction) Validate() error {
+	switch t {
+	case TTYResizeActionUnconfigured:
+	case TTYResizeActionReject:
+	case TTYResizeActionClamp:
+	default:
+		return fmt.Errorf("invalid resize action: %s", t)
+	}
+	return nil
+}
+
+func (b TTYBounds) clamp(value uint32) uint32 {
+	if value < b.Min {
+		return b.Min
+	}
+	if b.Max > 0 && value > b.Max {
+		return b.Max
+	}
+	return value
+}
+
+// windowSize is the payload of a window-change request as described in RFC 4254 section 6.7.
+type windowSize struct {
+	columns uint32
+	rows    uint32
+	width   uint32
+	height  uint32
+}
+
+func (w windowSize) String() string {
+	return fmt.Sprintf("%dx%d (%dx%d pixels)", w.columns, w.rows, w.width, w.height)
+}
+
+// checkWindow evaluates a window change against the TTY policy. Window changes are only allowed if a TTY has been
+// granted, regardless of the mode. In filter mode the dimensions are also checked against the TTY bounds. It returns
+// the size to pass to the backend, which may be clamped.
+func (s *sessionHandler) checkWindow(config Config, size windowSize) (Decision, windowSize, log.Message) {
+	mode := config.getPolicy(config.TTY.Mode)
+	decision := Decision{RequestType: "window-change", Subject: size.String(), Mode: mode}
+	if !s.hasPTY() {
+		return decision, size, log.UserMessage(
+			EWindowChangeRejected,
+			"Window change rejected.",
+			"The window change is rejected because no TTY has been allocated for the session.",
+		)
+	}
+	switch mode {
+	case ExecutionPolicyDisable:
+		return decision, size, log.UserMessage(
+			EWindowChangeRejected,
+			"Window change rejected.",
+			"The window change is rejected because TTY allocation is disabled in the security settings.",
+		)
+	case ExecutionPolicyFilter:
+	default:
+		return decision, size, nil
+	}
+	tty := config.TTY
+	clamped := windowSize{
+		columns: tty.Columns.clamp(size.columns),
+		rows:    tty.Rows.clamp(size.rows),
+		width:   tty.Width.clamp(size.width),
+		height:  tty.Height.clamp(size.height),
+	}
+	if clamped == size {
+		return decision, size, nil
+	}
+	if tty.ResizeAction != TTYResizeActionClamp {
+		return decision, size, log.UserMessage(
+			EWindowChangeRejected,
+			"Window change rejected.",
+			"The window change to %s is rejected because it is outside the allowed range.",
+			size,
+		)
+	}
+	decision.Outcome = DecisionOutcomeRewrite
+	decision.Rewrite = clamped.String()
+	message := log.NewMessage(
+		MWindowChangeClamped,
+		"Clamping the window change from %s to %s.",
+		size,
+		clamped,
+	)
+	s.sshConnection.addLabels(message)
+	s.logger.Debug(message.Label("channelId", s.channelID))
+	return decision, clamped, nil
+}
+
+// windowCoalescer limits how often window changes are passed to the backend. Window changes arriving within the
+// interval are held back and only the last one is passed on when the interval elapses.
+type windowCoalescer struct {
+	lock *sync.Mutex
+	// sendLock is held while a window change held back is passed to the backend, so stop can wait for it.
+	sendLock *sync.Mutex
+	clock    Clock
+	interval time.Duration
+	send     func(requestID uint64, size windowSize)
+	lastSent time.Time
+	// pending is the window change held back, if hasPending is set.
+	pending          windowSize
+	pendingRequestID uint64
+	hasPending       bool
+	timer            ClockTimer
+	stopped          bool
+}
+
+func newWindowCoalescer(
+	clock Clock,
+	interval time.Duration,
+	send func(requestID uint64, size windowSize),
+) *windowCoalescer {
+	return &windowCoalescer{
+		lock:     &sync.Mutex{},
+		sendLock: &sync.Mutex{},
+		clock:    clock,
+		interval: interval,
+		send:     send,
+	}
+}
+
+// submit holds the window change back if the interval has not elapsed since the last one was passed to the backend.
+// It returns false if the window change is not held back and must be passed to the backend by the caller.
+func (w *windowCoalescer) submit(requestID uint64, size windowSize) bool {
+	w.lock.Lock()
+	now := w.clock.Now()
+	if w.stopped {
+		w.lock.Unlock()
+		return true
+	}
+	if w.timer == nil && (w.lastSent.IsZero() || now.Sub(w.lastSent) >= w.interval) {
+		w.lastSent = now
+		w.lock.Unlock()
+		return false
+	}
+	w.pending = size
+	w.pendingRequestID = requestID
+	w.hasPending = true
+	if w.timer == nil {
+		w.timer = w.clock.AfterFunc(w.lastSent.Add(w.interval).Sub(now), w.flush)
+	}
+	w.lock.Unlock()
+	return true
+}
+
+// flush passes the window change held back to the backend.
+func (w *windowCoalescer) flush() {
+	w.sendLock.Lock()
+	defer w.sendLock.Unlock()
+	w.lock.Lock()
+	w.timer = nil
+	if w.stopped || !w.hasPending {
+		w.lock.Unlock()
+		return
+	}
+	size := w.pending
+	requestID := w.pendingRequestID
+	w.hasPending = false
+	w.lastSent = w.clock.Now()
+	w.lock.Unlock()
+	w.send(requestID, size)
+}
+
+// stop discards the window change held back, for example because the session was closed. If the window change is
+// being passed to the backend, stop waits until it is done, so the backend never gets a window change after stop
+// returns.
+func (w *windowCoalescer) stop() {
+	w.lock.Lock()
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.lock.Unlock()
+	w.sendLock.Lock()
+	w.sendLock.Unlock()
+}
+
+// sendWindow passes a window change held back by the coalescer to the backend.
+func (s *sessionHandler) sendWindow(requestID uint64, size windowSize) {
+	if err := s.backend.OnWindow(requestID, size.columns, size.rows, size.width, size.height); err != nil {
+		err := log.Wrap(
+			err,
+			EWindowChangeFailed,
+			"The backend failed to process the window change to %s.",
+			size,
+		)
+		s.sshConnection.addLabels(err)
+		s.logger.Debug(err.Label("channelId", s.channelID))
+	}
+}
@@ -0,0 +1,191 @@
+package security
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowFilter(t *testing.T) {
+	backend := &windowRecordingBackend{}
+	session := &sessionHandler{
+		config: Config{
+			TTY: TTYConfig{
+				Mode:    ExecutionPolicyFilter,
+				Allow:   []string{"xterm"},
+				Columns: TTYBounds{Min: 20, Max: 200},
+				Rows:    TTYBounds{Max: 100},
+			},
+		},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	assertWindowRejected(t, session.OnWindow(1, 80, 25, 800, 600))
+	assert.NoError(t, session.OnPtyRequest(2, "xterm", 80, 25, 800, 600, []byte{}))
+	assert.NoError(t, session.OnWindow(3, 120, 40, 1200, 800))
+	assertWindowRejected(t, session.OnWindow(4, 10, 40, 1200, 800))
+	assertWindowRejected(t, session.OnWindow(5, 120, 4000, 1200, 800))
+	assert.Equal(t, []windowSize{{120, 40, 1200, 800}}, backend.sizes)
+
+	session.config.TTY.ResizeAction = TTYResizeActionClamp
+	assert.NoError(t, session.OnWindow(6, 10, 4000, 1200, 800))
+	assert.Equal(t, windowSize{20, 100, 1200, 800}, backend.sizes[len(backend.sizes)-1])
+
+	session.config.TTY.Mode = ExecutionPolicyDisable
+	assertWindowRejected(t, session.OnWindow(7, 80, 25, 800, 600))
+
+	session.config.TTY.Mode = ExecutionPolicyEnable
+	assert.NoError(t, session.OnWindow(8, 10, 4000, 1200, 800))
+	assert.Equal(t, windowSize{10, 4000, 1200, 800}, backend.sizes[len(backend.sizes)-1])
+}
+
+func TestWindowWithoutPTYInEnableMode(t *testing.T) {
+	backend := &windowRecordingBackend{}
+	session := &sessionHandler{
+		config:  Config{TTY: TTYConfig{Mode: ExecutionPolicyEnable}},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger: log.NewTestLogger(t),
+	}
+
+	assertWindowRejected(t, session.OnWindow(1, 80, 25, 800, 600))
+	assert.Empty(t, backend.sizes)
+	assert.NoError(t, session.OnPtyRequest(2, "xterm", 80, 25, 800, 600, []byte{}))
+	assert.NoError(t, session.OnWindow(3, 80, 25, 800, 600))
+	assert.Equal(t, []windowSize{{80, 25, 800, 600}}, backend.sizes)
+}
+
+func TestWindowCoalescing(t *testing.T) {
+	clock := newFakeClock()
+	backend := &windowRecordingBackend{}
+	session := &sessionHandler{
+		config:  Config{},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger:     log.NewTestLogger(t),
+		ptyGranted: true,
+	}
+	session.window = newWindowCoalescer(clock, time.Second, session.sendWindow)
+
+	assert.NoError(t, session.OnWindow(1, 80, 25, 0, 0))
+	assert.NoError(t, session.OnWindow(2, 81, 25, 0, 0))
+	assert.NoError(t, session.OnWindow(3, 82, 25, 0, 0))
+	assert.Equal(t, []windowSize{{80, 25, 0, 0}}, backend.getSizes())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, []windowSize{{80, 25, 0, 0}, {82, 25, 0, 0}}, backend.getSizes())
+
+	clock.Advance(2 * time.Second)
+	assert.NoError(t, session.OnWindow(4, 83, 25, 0, 0))
+	assert.Equal(t, 3, len(backend.getSizes()))
+
+	assert.NoError(t, session.OnWindow(5, 84, 25, 0, 0))
+	session.OnClose()
+	clock.Advance(time.Second)
+	assert.Equal(t, 3, len(backend.getSizes()))
+}
+
+func TestWindowCoalescingFlushRacingClose(t *testing.T) {
+	clock := newFakeClock()
+	backend := &blockingWindowBackend{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+		lock:    &sync.Mutex{},
+	}
+	session := &sessionHandler{
+		config:  Config{},
+		backend: backend,
+		sshConnection: &sshConnectionHandler{
+			lock: &sync.Mutex{},
+		},
+		logger:     log.NewTestLogger(t),
+		ptyGranted: true,
+	}
+	session.window = newWindowCoalescer(clock, time.Second, session.sendWindow)
+	session.window.lastSent = clock.Now()
+	assert.NoError(t, session.OnWindow(1, 80, 25, 0, 0))
+
+	go clock.Advance(time.Second)
+	<-backend.entered
+	closed := make(chan struct{})
+	go func() {
+		session.OnClose()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("the session was closed while a window change was passed to the backend")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(backend.release)
+	<-closed
+	assert.Equal(t, []string{"window", "close"}, backend.getEvents())
+}
+
+func assertWindowRejected(t *testing.T, err error) {
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.Equal(t, EWindowChangeRejected, err.(log.Message).Code())
+}
+
+// windowRecordingBackend records the window changes passed to the backend.
+type windowRecordingBackend struct {
+	dummyBackend
+	lock  sync.Mutex
+	sizes []windowSize
+}
+
+func (w *windowRecordingBackend) OnWindow(_ uint64, columns uint32, rows uint32, width uint32, height uint32) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.sizes = append(w.sizes, windowSize{columns, rows, width, height})
+	return nil
+}
+
+func (w *windowRecordingBackend) getSizes() []windowSize {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]windowSize(nil), w.sizes...)
+}
+
+// blockingWindowBackend blocks in OnWindow until released and records the order of the window changes and closing.
+type blockingWindowBackend struct {
+	dummyBackend
+	entered chan struct{}
+	release chan struct{}
+	lock    *sync.Mutex
+	events  []string
+}
+
+func (b *blockingWindowBackend) OnWindow(_ uint64, _ uint32, _ uint32, _ uint32, _ uint32) error {
+	close(b.entered)
+	<-b.release
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.events = append(b.events, "window")
+	return nil
+}
+
+func (b *blockingWindowBackend) OnClose() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.events = append(b.events, "close")
+}
+
+func (b *blockingWindowBackend) getEvents() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]string(nil), b.events...)
+}
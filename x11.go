@@ -0,0 +1,84 @@
+package security
+
+import (
+	"fmt"
+)
+
+// X11Config controls X11 forwarding requests (`x11-req`).
+type X11Config struct {
+	// Mode configures how to treat X11 forwarding requests by SSH clients.
+	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Enforcement overrides the global enforcement mode for X11 forwarding requests.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
+	// AllowAuthProtocols takes effect when Mode is ExecutionPolicyFilter and only allows X11 forwarding with the
+	// specified authentication protocols, e.g. `MIT-MAGIC-COOKIE-1`. Entries may be prefixed with PatternPrefixGlob or
+	// PatternPrefixRegex.
+	AllowAuthProtocols []string `json:"allowAuthProtocols" yaml:"allowAuthProtocols"`
+	// RequireSingleConnection takes effect when Mode is ExecutionPolicyFilter and only allows X11 forwarding if the
+	// client requests that only a single X11 connection is forwarded.
+	RequireSingleConnection bool `json:"requireSingleConnection" yaml:"requireSingleConnection"`
+}
+
+// Validate validates the X11 configuration.
+func (x X11Config) Validate() error {
+	if err := x.Mode.Validate(); err != nil {
+		return fmt.Errorf("invalid mode (%w)", err)
+	}
+	if err := x.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement (%w)", err)
+	}
+	if err := validatePatterns(x.AllowAuthProtocols); err != nil {
+		return fmt.Errorf("invalid allowAuthProtocols list (%w)", err)
+	}
+	return nil
+}
+
+// check evaluates an X11 forwarding request against the policy. It returns the allow list entry that matched, if any,
+// and the reason of the rejection, or an empty string if the request is allowed.
+func (x X11Config) check(
+	parsed *parsedConfig,
+	mode ExecutionPolicy,
+	request x11RequestPayload,
+) (rule string, reason string) {
+	switch mode {
+	case ExecutionPolicyDisable:
+		return "", "X11 forwarding is disabled in the security settings."
+	case ExecutionPolicyFilter:
+		if x.RequireSingleConnection && !request.SingleConnection {
+			return "", "X11 forwarding is only allowed for a single connection."
+		}
+		var ok bool
+		if rule, ok = parsed.matchAny(x.AllowAuthProtocols, request.AuthProtocol); !ok {
+			return "", "The X11 authentication protocol does not match the allow list."
+		}
+	}
+	return rule, ""
+}
+
+// AgentForwardingConfig controls SSH agent forwarding requests (`auth-agent-req@openssh.com`).
+type AgentForwardingConfig struct {
+	// Mode configures how to treat agent forwarding requests by SSH clients. ExecutionPolicyFilter behaves like
+	// ExecutionPolicyDisable since there is nothing to filter on.
+	Mode ExecutionPolicy `json:"mode" yaml:"mode" default:""`
+	// Enforcement overrides the global enforcement mode for agent forwarding requests.
+	Enforcement EnforcementMode `json:"enforcement" yaml:"enforcement"`
+}
+
+// Validate validates the agent forwarding configuration.
+func (a AgentForwardingConfig) Validate() error {
+	if err := a.Mode.Validate(); err != nil {
+		return fmt.Errorf("invalid mode (%w)", err)
+	}
+	if err := a.Enforcement.Validate(); err != nil {
+		return fmt.Errorf("invalid enforcement (%w)", err)
+	}
+	return nil
+}
+
+// x11RequestPayload is the payload of the x11-req channel request as described in RFC 4254 section 6.3.1.
+type x11RequestPayload struct {
+	SingleConnection bool
+	AuthProtocol     string
+	AuthCookie       string
+	ScreenNumber     uint32
+}